@@ -0,0 +1,337 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+
+	alertKeyPrefix = "iquota:alert:"
+
+	// Default gap in percent between the firing and clearing thresholds
+	DefaultAlertHysteresis = 5
+)
+
+var (
+	// Remaining grace time as reported by VAST: "D HH:MM:SS" or "HH:MM:SS"
+	graceRemainingRe = regexp.MustCompile(`^(?:(\d+)\s+)?(\d+):(\d{2}):(\d{2})$`)
+)
+
+// Rule for alerting on a directory quota. The rule fires when usage reaches
+// Percent of the limit and clears once it drops below Clear.
+type AlertRule struct {
+	Name string `mapstructure:"name" json:"name"`
+
+	// Usage to compare: bytes or inodes. Defaults to bytes
+	Usage string `mapstructure:"usage" json:"usage"`
+
+	// Limit to compare usage against: soft or hard. Defaults to soft
+	Limit string `mapstructure:"limit" json:"limit"`
+
+	// Percent of the limit used to fire the alert
+	Percent float64 `mapstructure:"percent" json:"percent"`
+
+	// Percent of the limit used below which a firing alert clears. Defaults to
+	// DefaultAlertHysteresis below Percent
+	Clear float64 `mapstructure:"clear" json:"clear"`
+
+	// If set only fire once the soft limit is exceeded and the grace period
+	// expires within this many hours
+	GraceHours float64 `mapstructure:"grace_hours" json:"grace_hours,omitempty"`
+
+	// Only apply to directories under these paths. Defaults to all
+	Paths []string `mapstructure:"paths" json:"paths,omitempty"`
+
+	// Names of the webhooks to deliver alerts to. Defaults to all
+	Webhooks []string `mapstructure:"webhooks" json:"webhooks,omitempty"`
+}
+
+// Alert raised by a rule for a directory quota
+type Alert struct {
+	Rule    string    `json:"rule"`
+	Path    string    `json:"path"`
+	Status  string    `json:"status"`
+	Usage   string    `json:"usage"`
+	Limit   string    `json:"limit"`
+	Percent float64   `json:"percent"`
+	Quota   *Quota    `json:"quota"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+
+	// Time the quota was first found missing from the cache
+	Missing *time.Time `json:"missing,omitempty"`
+}
+
+// Validate sets defaults and checks the rule settings
+func (r *AlertRule) Validate() error {
+	if len(r.Name) == 0 {
+		return errors.New("Alert rule name is required")
+	}
+
+	if len(r.Usage) == 0 {
+		r.Usage = UsageBytes
+	}
+	if r.Usage != UsageBytes && r.Usage != UsageInodes {
+		return fmt.Errorf("Invalid usage for alert rule %s. Must be one of bytes or inodes", r.Name)
+	}
+
+	if len(r.Limit) == 0 {
+		r.Limit = LimitSoft
+	}
+	if r.Limit != LimitSoft && r.Limit != LimitHard {
+		return fmt.Errorf("Invalid limit for alert rule %s. Must be one of soft or hard", r.Name)
+	}
+
+	if r.Percent <= 0 {
+		return fmt.Errorf("Invalid percent for alert rule %s. Must be positive", r.Name)
+	}
+
+	if r.Clear == 0 {
+		r.Clear = r.Percent - DefaultAlertHysteresis
+	}
+	// Usage never drops below a clear of 0 so the alert would never resolve
+	if r.Clear <= 0 || r.Clear > r.Percent {
+		return fmt.Errorf("Invalid clear for alert rule %s. Must be between 0 and percent, set clear when percent is %d or less", r.Name, DefaultAlertHysteresis)
+	}
+
+	if r.GraceHours < 0 {
+		return fmt.Errorf("Invalid grace_hours for alert rule %s. Must be positive", r.Name)
+	}
+
+	return nil
+}
+
+// Matches returns true if the rule applies to path
+func (r *AlertRule) Matches(path string) bool {
+	if len(r.Paths) == 0 {
+		return true
+	}
+
+	for _, p := range r.Paths {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Percent of the rule's limit used by q
+func (r *AlertRule) percent(q *Quota) float64 {
+	if r.Limit == LimitHard {
+		return q.PercentHard(r.Usage)
+	}
+
+	return q.PercentSoft(r.Usage)
+}
+
+// Firing returns true if q should fire the rule at time now
+func (r *AlertRule) Firing(q *Quota, now time.Time) bool {
+	if r.percent(q) < r.Percent {
+		return false
+	}
+
+	if r.GraceHours == 0 {
+		return true
+	}
+
+	if q.PercentSoft(r.Usage) < 100 {
+		return false
+	}
+
+	remaining, ok := GraceRemaining(q.GraceExpiration, now)
+	return ok && remaining.Hours() < r.GraceHours
+}
+
+// Cleared returns true if a firing alert for q should be resolved. Usage must
+// drop below the clear threshold, or for grace rules below the soft limit.
+func (r *AlertRule) Cleared(q *Quota) bool {
+	if r.GraceHours > 0 && q.PercentSoft(r.Usage) < 100 {
+		return true
+	}
+
+	return r.percent(q) < r.Clear
+}
+
+// Evaluate checks q against the rule given the currently active alert, which
+// is nil if the rule is not firing for q. Returns the new or resolved alert
+// or nil if nothing changed.
+func (r *AlertRule) Evaluate(q *Quota, active *Alert, now time.Time) *Alert {
+	if active == nil {
+		if !r.Firing(q, now) {
+			return nil
+		}
+
+		return &Alert{
+			Rule:    r.Name,
+			Path:    q.Path,
+			Status:  AlertFiring,
+			Usage:   r.Usage,
+			Limit:   r.Limit,
+			Percent: r.percent(q),
+			Quota:   q,
+			Started: now,
+			Updated: now,
+		}
+	}
+
+	if !r.Cleared(q) {
+		return nil
+	}
+
+	resolved := active.Resolve(now)
+	resolved.Percent = r.percent(q)
+	resolved.Quota = q
+	resolved.Missing = nil
+
+	return resolved
+}
+
+// Gone returns true once the quota of a firing alert has been missing from the
+// cache for longer than after. The first time the quota is missed the time is
+// recorded in Missing, which the caller must save.
+func (a *Alert) Gone(now time.Time, after time.Duration) bool {
+	if a.Missing == nil {
+		a.Missing = &now
+	}
+
+	return now.Sub(*a.Missing) > after
+}
+
+// Resolve returns a resolved copy of a firing alert
+func (a *Alert) Resolve(now time.Time) *Alert {
+	resolved := *a
+	resolved.Status = AlertResolved
+	resolved.Updated = now
+
+	return &resolved
+}
+
+// GraceRemaining parses the grace period expiration of a quota. Accepts the
+// time remaining as "D HH:MM:SS" or "HH:MM:SS" or an RFC3339 expiry time.
+// Returns false if the grace period is not running.
+func GraceRemaining(expiration string, now time.Time) (time.Duration, bool) {
+	expiration = strings.TrimSpace(expiration)
+	if len(expiration) == 0 {
+		return 0, false
+	}
+
+	if t, err := time.Parse(time.RFC3339, expiration); err == nil {
+		return t.Sub(now), true
+	}
+
+	m := graceRemainingRe.FindStringSubmatch(expiration)
+	if m == nil {
+		return 0, false
+	}
+
+	var parts [4]int
+	for i, s := range m[1:] {
+		if len(s) > 0 {
+			parts[i], _ = strconv.Atoi(s)
+		}
+	}
+
+	return time.Duration(parts[0])*24*time.Hour +
+		time.Duration(parts[1])*time.Hour +
+		time.Duration(parts[2])*time.Minute +
+		time.Duration(parts[3])*time.Second, true
+}
+
+func alertKey(rule, path string) string {
+	return alertKeyPrefix + rule + ":" + path
+}
+
+// SetAlert saves an active alert
+func (c *Cache) SetAlert(a *Alert) error {
+	out, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", alertKey(a.Rule, a.Path), out)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":  err.Error(),
+			"rule": a.Rule,
+			"path": a.Path,
+		}).Error("Failed to save alert")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAlert removes the active alert for rule and path
+func (c *Cache) DeleteAlert(rule, path string) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", alertKey(rule, path))
+	return err
+}
+
+// ListAlerts returns all active alerts ordered by when they started
+func (c *Cache) ListAlerts() ([]*Alert, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", alertKeyPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make([]*Alert, 0, len(keys))
+	for _, key := range keys {
+		rawJson, err := redis.Bytes(conn.Do("GET", key))
+		if err != nil {
+			continue
+		}
+
+		a := &Alert{}
+		err = json.Unmarshal(rawJson, a)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"key": key,
+			}).Error("Invalid alert")
+			continue
+		}
+
+		alerts = append(alerts, a)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Started.Before(alerts[j].Started)
+	})
+
+	return alerts, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule  AlertRule
+		valid bool
+		clear float64
+	}{
+		{AlertRule{Name: "a", Percent: 90}, true, 85},
+		{AlertRule{Name: "a", Percent: 90, Clear: 80}, true, 80},
+		{AlertRule{Name: "a", Percent: 5.5}, true, 0.5},
+		// Default clear would be 0 which never resolves
+		{AlertRule{Name: "a", Percent: 5}, false, 0},
+		{AlertRule{Name: "a", Percent: 3}, false, 0},
+		{AlertRule{Name: "a", Percent: 3, Clear: 1}, true, 1},
+		{AlertRule{Name: "a", Percent: 90, Clear: 95}, false, 0},
+		{AlertRule{Name: "a", Percent: 90, Clear: -1}, false, 0},
+		{AlertRule{Name: "a", Percent: 0}, false, 0},
+		{AlertRule{Percent: 90}, false, 0},
+		{AlertRule{Name: "a", Percent: 90, Usage: "blocks"}, false, 0},
+		{AlertRule{Name: "a", Percent: 90, Limit: "quota"}, false, 0},
+		{AlertRule{Name: "a", Percent: 90, GraceHours: -1}, false, 0},
+	}
+
+	for i, test := range tests {
+		r := test.rule
+		err := r.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%d: Validate(%+v) error = %v, want valid %t", i, test.rule, err, test.valid)
+			continue
+		}
+		if test.valid && r.Clear != test.clear {
+			t.Errorf("%d: expected clear %.1f got %.1f", i, test.clear, r.Clear)
+		}
+	}
+}
+
+func TestAlertRuleHysteresis(t *testing.T) {
+	r := &AlertRule{Name: "near", Percent: 90}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	var active *Alert
+
+	// Usage as a percent of the soft limit over time and the expected status
+	// of any alert raised or resolved
+	tests := []struct {
+		used   int
+		status string
+	}{
+		{80, ""},
+		{90, AlertFiring},
+		{95, ""},
+		// Between clear and percent the alert keeps firing
+		{88, ""},
+		{85, ""},
+		{91, ""},
+		{84, AlertResolved},
+		{88, ""},
+		{89, ""},
+		{100, AlertFiring},
+	}
+
+	for i, test := range tests {
+		q := &Quota{Path: "/projects/grp-a", Used: test.used, SoftLimit: 100}
+		alert := r.Evaluate(q, active, now)
+
+		status := ""
+		if alert != nil {
+			status = alert.Status
+		}
+		if status != test.status {
+			t.Fatalf("%d: used %d%% expected status %q got %q", i, test.used, test.status, status)
+		}
+
+		switch status {
+		case AlertFiring:
+			active = alert
+		case AlertResolved:
+			if alert.Percent != float64(test.used) || alert.Started != active.Started {
+				t.Errorf("%d: resolved alert not updated: %+v", i, alert)
+			}
+			active = nil
+		}
+	}
+}
+
+func TestAlertGone(t *testing.T) {
+	start := time.Now()
+	alert := &Alert{Rule: "near", Path: "/projects/grp-a", Status: AlertFiring}
+
+	// Minutes since the quota was first missed and whether the alert should
+	// resolve when it must be missing for 5 minutes
+	tests := []struct {
+		minutes int
+		gone    bool
+	}{
+		{0, false},
+		{3, false},
+		{5, false},
+		{6, true},
+	}
+
+	for i, test := range tests {
+		now := start.Add(time.Duration(test.minutes) * time.Minute)
+		if gone := alert.Gone(now, 5*time.Minute); gone != test.gone {
+			t.Errorf("%d: after %d minutes expected gone %t got %t", i, test.minutes, test.gone, gone)
+		}
+		if alert.Missing == nil || !alert.Missing.Equal(start) {
+			t.Errorf("%d: expected missing since %s got %v", i, start, alert.Missing)
+		}
+	}
+}
+
+func TestAlertRuleGrace(t *testing.T) {
+	r := &AlertRule{Name: "grace", Percent: 100, GraceHours: 24}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		used   int
+		expire string
+		firing bool
+	}{
+		{110, "0 12:00:00", true},
+		{110, "2 00:00:00", false},
+		{110, "", false},
+		{90, "0 12:00:00", false},
+		{110, now.Add(time.Hour).Format(time.RFC3339), true},
+	}
+
+	for _, test := range tests {
+		q := &Quota{Used: test.used, SoftLimit: 100, GraceExpiration: test.expire}
+		if got := r.Firing(q, now); got != test.firing {
+			t.Errorf("Firing(used %d, expire %q) = %t, want %t", test.used, test.expire, got, test.firing)
+		}
+	}
+
+	// Grace alerts clear once usage is back under the soft limit
+	if r.Cleared(&Quota{Used: 101, SoftLimit: 100}) {
+		t.Error("Grace alert cleared while over the soft limit")
+	}
+	if !r.Cleared(&Quota{Used: 99, SoftLimit: 100}) {
+		t.Error("Grace alert not cleared under the soft limit")
+	}
+}
+
+func TestGraceRemaining(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expiration string
+		want       time.Duration
+		ok         bool
+	}{
+		{"", 0, false},
+		{"   ", 0, false},
+		{"7 00:00:00", 7 * 24 * time.Hour, true},
+		{"1 02:03:04", 26*time.Hour + 3*time.Minute + 4*time.Second, true},
+		{"02:03:04", 2*time.Hour + 3*time.Minute + 4*time.Second, true},
+		{" 0 00:30:00 ", 30 * time.Minute, true},
+		{"2020-06-02T12:00:00Z", 24 * time.Hour, true},
+		{"2020-06-01T11:00:00Z", -time.Hour, true},
+		{"none", 0, false},
+		{"1 2:03", 0, false},
+		{"02:3:04", 0, false},
+	}
+
+	for _, test := range tests {
+		got, ok := GraceRemaining(test.expiration, now)
+		if ok != test.ok || got != test.want {
+			t.Errorf("GraceRemaining(%q) = %s, %t, want %s, %t", test.expiration, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNotSupported = errors.New("not supported by storage backend")
+	ErrNoBackend    = errors.New("no storage backend found for path")
+
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Quota limits to set on a directory. A zero hard limit is left to the
+// backend to choose based on the soft limit.
+type Limits struct {
+	SoftLimit       int `json:"soft_limit"`
+	HardLimit       int `json:"hard_limit"`
+	SoftLimitInodes int `json:"soft_limit_inodes"`
+	HardLimitInodes int `json:"hard_limit_inodes"`
+}
+
+// File limit for new quotas when none is given
+const DefaultFilesLimit = 200000000 // 200M
+
+const (
+	QuotaCreate = "create"
+	QuotaUpdate = "update"
+	QuotaDelete = "delete"
+)
+
+// Change made to a directory quota through a storage backend
+type QuotaChange struct {
+	Action  string  `json:"action"`
+	Path    string  `json:"path"`
+	Backend string  `json:"backend"`
+	DryRun  bool    `json:"dry_run"`
+	Current *Quota  `json:"current,omitempty"`
+	Limits  *Limits `json:"limits,omitempty"`
+}
+
+// Storage backend that owns directory quotas for one or more paths
+type Backend interface {
+	// Name of the backend from the config
+	Name() string
+
+	// GetQuota fetches the current quota for path from the storage system.
+	// Returns ErrNotFound if path has no quota.
+	GetQuota(path string) (*Quota, error)
+
+	// SetQuota creates or updates the quota limits for path
+	SetQuota(path string, limits *Limits) error
+
+	// DeleteQuota removes the quota on path. Returns ErrNotFound if path has
+	// no quota.
+	DeleteQuota(path string) error
+}
+
+// Backend that picks hard limits left at zero. Lets callers report the limits
+// a change will actually set.
+type LimitsResolver interface {
+	// ResolveLimits returns the limits the backend sets for limits
+	ResolveLimits(limits *Limits) *Limits
+}
+
+// ResolveLimits returns the limits backend sets for limits. Backends that
+// don't choose any limits themselves set limits unchanged.
+func ResolveLimits(backend Backend, limits *Limits) *Limits {
+	if r, ok := backend.(LimitsResolver); ok {
+		return r.ResolveLimits(limits)
+	}
+
+	l := *limits
+	return &l
+}
+
+// Backend that can fetch every quota on its storage system at once. Used by
+// iquota-server to populate the quota cache in place of ivast or ipanfs.
+type Collector interface {
+	Backend
+
+	// FetchAllQuotas returns all quotas on the storage system. Should give up
+	// once ctx is done.
+	FetchAllQuotas(ctx context.Context) ([]*Quota, error)
+}
+
+// Driver creates a new Backend from its config section
+type Driver func(name string, conf *viper.Viper) (Backend, error)
+
+// RegisterDriver makes a backend driver available by name. Driver packages
+// should call this from init()
+func RegisterDriver(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if _, dup := drivers[name]; dup {
+		panic("iquota: RegisterDriver called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Backend that translates between client paths and the storage-native paths
+// of its routes
+type routedBackend struct {
+	Backend
+	routes Routes
+}
+
+func (b *routedBackend) toStorage(path string) string {
+	if route, ok := b.routes.Match(path); ok {
+		return route.ToStorage(path)
+	}
+
+	return path
+}
+
+func (b *routedBackend) fromStorage(path string) string {
+	path, _ = b.routes.FromStorage(b.Name(), path)
+	return path
+}
+
+func (b *routedBackend) GetQuota(path string) (*Quota, error) {
+	quota, err := b.Backend.GetQuota(b.toStorage(path))
+	if err != nil {
+		return nil, err
+	}
+
+	quota.Path = filepath.Clean(path)
+	return quota, nil
+}
+
+func (b *routedBackend) SetQuota(path string, limits *Limits) error {
+	return b.Backend.SetQuota(b.toStorage(path), limits)
+}
+
+func (b *routedBackend) ResolveLimits(limits *Limits) *Limits {
+	return ResolveLimits(b.Backend, limits)
+}
+
+func (b *routedBackend) DeleteQuota(path string) error {
+	return b.Backend.DeleteQuota(b.toStorage(path))
+}
+
+type routedCollector struct {
+	*routedBackend
+	collector Collector
+}
+
+func (c *routedCollector) FetchAllQuotas(ctx context.Context) ([]*Quota, error) {
+	quotas, err := c.collector.FetchAllQuotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range quotas {
+		q.Path = c.fromStorage(q.Path)
+	}
+
+	return quotas, nil
+}
+
+// Set of configured storage backends
+type Backends struct {
+	backends map[string]Backend
+	routes   Routes
+}
+
+// NewBackends creates all storage backends configured under key. Each
+// backend must set the driver to use. Paths a backend owns come from routes
+// naming the backend and the paths listed in its config, which are routed
+// unchanged. For example:
+//
+//	backends:
+//	    vast:
+//	        driver: vast
+//	        paths:
+//	            - /vast
+//
+// Backends translate paths using their routes so callers always use the
+// client spelling of a path.
+func NewBackends(v *viper.Viper, key string, routes Routes) (*Backends, error) {
+	b := &Backends{backends: make(map[string]Backend)}
+
+	for name := range v.GetStringMap(key) {
+		conf := v.Sub(key + "." + name)
+		if conf == nil {
+			return nil, fmt.Errorf("Invalid config for backend %s", name)
+		}
+
+		driversMu.RLock()
+		driver, ok := drivers[conf.GetString("driver")]
+		driversMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("Unknown driver for backend %s: %s", name, conf.GetString("driver"))
+		}
+
+		backend, err := driver(name, conf)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create backend %s: %s", name, err)
+		}
+
+		b.backends[name] = backend
+		for _, p := range conf.GetStringSlice("paths") {
+			p = filepath.Clean(p)
+			b.routes = append(b.routes, &Route{Prefix: p, Backend: name, StoragePath: p})
+		}
+	}
+
+	b.routes = append(b.routes, routes...)
+	b.routes.sort()
+	if err := b.routes.check(); err != nil {
+		return nil, err
+	}
+
+	for name, backend := range b.backends {
+		var own Routes
+		for _, r := range b.routes {
+			if r.Backend == name {
+				own = append(own, r)
+			}
+		}
+
+		routed := &routedBackend{Backend: backend, routes: own}
+		if c, ok := backend.(Collector); ok {
+			b.backends[name] = &routedCollector{routedBackend: routed, collector: c}
+		} else {
+			b.backends[name] = routed
+		}
+	}
+
+	return b, nil
+}
+
+// Get returns the backend with the given name
+func (b *Backends) Get(name string) (Backend, bool) {
+	backend, ok := b.backends[name]
+	return backend, ok
+}
+
+// Routes returns the routing table including the paths set on each backend
+func (b *Backends) Routes() Routes {
+	return b.routes
+}
+
+// ForPath returns the backend that owns path. Returns ErrNoBackend if the
+// most specific route for path names a source without a configured backend.
+func (b *Backends) ForPath(path string) (Backend, error) {
+	route, ok := b.routes.Match(path)
+	if !ok {
+		return nil, ErrNoBackend
+	}
+
+	backend, ok := b.backends[route.Backend]
+	if !ok {
+		return nil, ErrNoBackend
+	}
+
+	return backend, nil
+}
@@ -9,12 +9,17 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+const (
+	negKeyPrefix = "iquota:neg:"
+)
+
 var (
 	ErrNotFound = errors.New("not found")
 )
@@ -22,20 +27,54 @@ var (
 type Quota struct {
 	Path            string `json:"path"`
 	GracePeriod     string `json:"pretty_grace_period"`
+	GraceExpiration string `json:"pretty_grace_period_expiration,omitempty"`
 	HardLimit       int    `json:"hard_limit"`
 	SoftLimit       int    `json:"soft_limit"`
 	Used            int    `json:"used"`
 	HardLimitInodes int    `json:"hard_limit_inodes"`
 	SoftLimitInodes int    `json:"soft_limit_inodes"`
 	UsedInodes      int    `json:"used_inodes"`
+
+	// Storage system the quota was collected from (vast, panfs)
+	Source string `json:"source,omitempty"`
+}
+
+// Quotas visible to the current user shown on the dashboard
+type Dashboard struct {
+	UID    string   `json:"uid"`
+	Groups []string `json:"groups"`
+	Admin  bool     `json:"admin"`
+	Quotas []*Quota `json:"quotas"`
 }
 
 type Cache struct {
 	Expire int
+
+	// Redis server address. Defaults to the redis config setting
+	Redis string
+
+	// Prefix to user home directories. Defaults to the home_dir config setting
+	HomeDir string
 }
 
-func redisDial() (redis.Conn, error) {
-	conn, err := redis.Dial("tcp", viper.GetString("redis"))
+func (c *Cache) redisAddr() string {
+	if len(c.Redis) > 0 {
+		return c.Redis
+	}
+
+	return viper.GetString("redis")
+}
+
+func (c *Cache) homeDir() string {
+	if len(c.HomeDir) > 0 {
+		return c.HomeDir
+	}
+
+	return viper.GetString("home_dir")
+}
+
+func (c *Cache) redisDial() (redis.Conn, error) {
+	conn, err := redis.Dial("tcp", c.redisAddr())
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err": err.Error(),
@@ -47,7 +86,7 @@ func redisDial() (redis.Conn, error) {
 }
 
 func (c *Cache) redisFind(pattern string) ([]*Quota, error) {
-	conn, err := redisDial()
+	conn, err := c.redisDial()
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +112,12 @@ func (c *Cache) redisFind(pattern string) ([]*Quota, error) {
 	var quotas []*Quota
 
 	for _, key := range keys {
-		if len(pattern) > 0 && strings.HasPrefix(key, viper.GetString("home_dir")) {
+		// Quotas are keyed by absolute path, skip any other keys
+		if !strings.HasPrefix(key, "/") {
+			continue
+		}
+
+		if len(pattern) > 0 && strings.HasPrefix(key, c.homeDir()) {
 			continue
 		}
 
@@ -115,7 +159,7 @@ func (c *Cache) unmarshalQuota(conn redis.Conn, key string) (*Quota, error) {
 }
 
 func (c *Cache) redisGet(key string) (*Quota, error) {
-	conn, err := redisDial()
+	conn, err := c.redisDial()
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +169,7 @@ func (c *Cache) redisGet(key string) (*Quota, error) {
 }
 
 func (c *Cache) redisSet(key string, iq *Quota) error {
-	conn, err := redisDial()
+	conn, err := c.redisDial()
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err": err.Error(),
@@ -159,6 +203,17 @@ func (c *Cache) SetDirectoryQuotaCache(path string, iq *Quota) error {
 	return c.redisSet(path, iq)
 }
 
+func (c *Cache) DeleteDirectoryQuotaCache(path string) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", path)
+	return err
+}
+
 func (c *Cache) GetDirectoryQuotaCache(path string) (*Quota, error) {
 	c.redisFind("grp-ezurek")
 	return c.redisGet(path)
@@ -167,3 +222,53 @@ func (c *Cache) GetDirectoryQuotaCache(path string) (*Quota, error) {
 func (c *Cache) SearchDirectoryQuotaCache(pattern string) ([]*Quota, error) {
 	return c.redisFind(pattern)
 }
+
+// SetNegativeCache records that path has no quota for expire seconds so
+// lookups don't keep asking the storage backend
+func (c *Cache) SetNegativeCache(path string, expire int) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SETEX", negKeyPrefix+path, expire, 1)
+	return err
+}
+
+// IsNegativeCached returns true if path was recently found to have no quota
+func (c *Cache) IsNegativeCached(path string) bool {
+	conn, err := c.redisDial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	found, err := redis.Bool(conn.Do("EXISTS", negKeyPrefix+path))
+	return err == nil && found
+}
+
+// CacheQuotas caches all quotas fetched by a collector at t and records their
+// usage history. Returns the number of quotas cached.
+func (c *Cache) CacheQuotas(quotas []*Quota, t time.Time) int {
+	count := 0
+	for _, q := range quotas {
+		err := c.SetDirectoryQuotaCache(q.Path, q)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"path":  q.Path,
+				"error": err,
+			}).Error("Failed to set directory quota cache in redis")
+			continue
+		}
+
+		err = c.AddHistory(q, t)
+		if err != nil {
+			logrus.Errorf("Failed to record quota history for %s: %s", q.Path, err)
+		}
+
+		count++
+	}
+
+	return count
+}
@@ -0,0 +1,304 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ubccr/iquota"
+)
+
+const (
+	QuotaEndpoint   = "/quota"
+	ExportEndpoint  = "/export"
+	OverEndpoint    = "/over"
+	RequestEndpoint = "/requests"
+	TempEndpoint    = "/temp"
+	HistoryEndpoint = "/history"
+
+	CollectorsEndpoint = "/collectors"
+	MeEndpoint         = "/me"
+)
+
+// Filter for quota lookups. Only the first non-empty field of Path, User and
+// Group is used. If all are empty the quota of the authenticated user's home
+// directory is returned.
+type QuotaQuery struct {
+	Path  string
+	User  string
+	Group string
+
+	// Also return the quotas of all parent directories of Path
+	Parents bool
+}
+
+func (q QuotaQuery) params() url.Values {
+	params := url.Values{}
+	if len(q.Path) > 0 {
+		params.Add("path", q.Path)
+		if q.Parents {
+			params.Add("parents", "true")
+		}
+	} else if len(q.User) > 0 {
+		params.Add("user", q.User)
+	} else if len(q.Group) > 0 {
+		params.Add("group", q.Group)
+	}
+
+	return params
+}
+
+// Options for the over quota report. Zero values use the server defaults.
+type OverQuery struct {
+	// Percent of the limit used to report a quota
+	Percent float64
+
+	// Limit to compare usage against: iquota.LimitSoft or iquota.LimitHard
+	Limit string
+
+	// Usage to compare: iquota.UsageBytes or iquota.UsageInodes
+	Type string
+}
+
+// Quota returns the cached directory quotas matching q. Returns
+// iquota.ErrNotFound if there are none.
+func (c *Client) Quota(q QuotaQuery) ([]*iquota.Quota, error) {
+	var quotas []*iquota.Quota
+	err := c.requestJSON(http.MethodGet, c.endpoint(QuotaEndpoint, q.params()), nil, &quotas)
+	if err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
+
+// Me returns the authenticated user with their home and group directory
+// quotas
+func (c *Client) Me() (*iquota.Dashboard, error) {
+	var d iquota.Dashboard
+	err := c.requestJSON(http.MethodGet, c.endpoint(MeEndpoint, nil), nil, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// Export returns all cached directory quotas (super-user only)
+func (c *Client) Export() ([]*iquota.Quota, error) {
+	var quotas []*iquota.Quota
+	err := c.requestJSON(http.MethodGet, c.endpoint(ExportEndpoint, nil), nil, &quotas)
+	if err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
+
+// Over returns all quotas over a percent of their limit (super-user only)
+func (c *Client) Over(q OverQuery) ([]*iquota.OverQuota, error) {
+	params := url.Values{}
+	if q.Percent > 0 {
+		params.Add("percent", strconv.FormatFloat(q.Percent, 'f', -1, 64))
+	}
+	if len(q.Limit) > 0 {
+		params.Add("limit", q.Limit)
+	}
+	if len(q.Type) > 0 {
+		params.Add("type", q.Type)
+	}
+
+	var quotas []*iquota.OverQuota
+	err := c.requestJSON(http.MethodGet, c.endpoint(OverEndpoint, params), nil, &quotas)
+	if err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
+
+// History returns the usage history of the quota on path for the last days.
+// If days is zero the server default is used.
+func (c *Client) History(path string, days int) ([]*iquota.HistorySample, error) {
+	params := url.Values{}
+	params.Add("path", path)
+	if days > 0 {
+		params.Add("days", strconv.Itoa(days))
+	}
+
+	var samples []*iquota.HistorySample
+	err := c.requestJSON(http.MethodGet, c.endpoint(HistoryEndpoint, params), nil, &samples)
+	if err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+func quotaParams(path string, dryRun bool) url.Values {
+	params := url.Values{}
+	params.Add("path", path)
+	params.Add("dry_run", strconv.FormatBool(dryRun))
+
+	return params
+}
+
+// SetQuota creates or updates the quota on path (super-user only). If dryRun
+// is true nothing is changed and the returned change shows what would be done.
+func (c *Client) SetQuota(path string, limits *iquota.Limits, dryRun bool) (*iquota.QuotaChange, error) {
+	var change iquota.QuotaChange
+	err := c.requestJSON(http.MethodPut, c.endpoint(QuotaEndpoint, quotaParams(path, dryRun)), limits, &change)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+// DeleteQuota removes the quota on path (super-user only)
+func (c *Client) DeleteQuota(path string, dryRun bool) (*iquota.QuotaChange, error) {
+	var change iquota.QuotaChange
+	err := c.requestJSON(http.MethodDelete, c.endpoint(QuotaEndpoint, quotaParams(path, dryRun)), nil, &change)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+func requestPath(id int64, action string) string {
+	path := RequestEndpoint + "/" + strconv.FormatInt(id, 10)
+	if len(action) > 0 {
+		path += "/" + action
+	}
+
+	return path
+}
+
+// SubmitRequest submits a request to increase the quota on r.Path. Only Path,
+// SoftLimit, SoftLimitInodes and Justification are used.
+func (c *Client) SubmitRequest(r *iquota.QuotaRequest) (*iquota.QuotaRequest, error) {
+	var created iquota.QuotaRequest
+	err := c.requestJSON(http.MethodPost, c.endpoint(RequestEndpoint, nil), r, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// ListRequests returns quota requests. Super-users see requests from all
+// users. If status is not empty only requests with that status are returned.
+func (c *Client) ListRequests(status string) ([]*iquota.QuotaRequest, error) {
+	params := url.Values{}
+	if len(status) > 0 {
+		params.Add("status", status)
+	}
+
+	var requests []*iquota.QuotaRequest
+	err := c.requestJSON(http.MethodGet, c.endpoint(RequestEndpoint, params), nil, &requests)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// GetRequest returns the quota request with the given ID
+func (c *Client) GetRequest(id int64) (*iquota.QuotaRequest, error) {
+	var r iquota.QuotaRequest
+	err := c.requestJSON(http.MethodGet, c.endpoint(requestPath(id, ""), nil), nil, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func (c *Client) decideRequest(id int64, action, comment string) (*iquota.QuotaRequest, error) {
+	var r iquota.QuotaRequest
+	err := c.requestJSON(http.MethodPost, c.endpoint(requestPath(id, action), nil), &iquota.RequestDecision{Comment: comment}, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// ApproveRequest approves a quota request and applies the new limits
+// (super-user only)
+func (c *Client) ApproveRequest(id int64, comment string) (*iquota.QuotaRequest, error) {
+	return c.decideRequest(id, "approve", comment)
+}
+
+// DenyRequest denies a quota request (super-user only)
+func (c *Client) DenyRequest(id int64, comment string) (*iquota.QuotaRequest, error) {
+	return c.decideRequest(id, "deny", comment)
+}
+
+// ListTempIncreases returns all temporary quota increases (super-user only)
+func (c *Client) ListTempIncreases() ([]*iquota.TempIncrease, error) {
+	var increases []*iquota.TempIncrease
+	err := c.requestJSON(http.MethodGet, c.endpoint(TempEndpoint, nil), nil, &increases)
+	if err != nil {
+		return nil, err
+	}
+
+	return increases, nil
+}
+
+// SetTempIncrease applies a temporary increase to t.Path until t.Expires
+// (super-user only). Returns the increase with the original limits that will
+// be restored.
+func (c *Client) SetTempIncrease(t *iquota.TempIncrease) (*iquota.TempIncrease, error) {
+	var created iquota.TempIncrease
+	err := c.requestJSON(http.MethodPost, c.endpoint(TempEndpoint, nil), t, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// RevertTempIncrease restores the original limits on path now (super-user
+// only)
+func (c *Client) RevertTempIncrease(path string) (*iquota.TempIncrease, error) {
+	params := url.Values{}
+	params.Add("path", path)
+
+	var t iquota.TempIncrease
+	err := c.requestJSON(http.MethodDelete, c.endpoint(TempEndpoint, params), nil, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Collectors returns the quota collectors with their last run (super-user
+// only)
+func (c *Client) Collectors() ([]*iquota.CollectorInfo, error) {
+	var collectors []*iquota.CollectorInfo
+	err := c.requestJSON(http.MethodGet, c.endpoint(CollectorsEndpoint, nil), nil, &collectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectors, nil
+}
+
+// RefreshCollector runs a collector hosted by the server now (super-user
+// only). The collector runs in the background.
+func (c *Client) RefreshCollector(name string) (*iquota.CollectorInfo, error) {
+	var info iquota.CollectorInfo
+	err := c.requestJSON(http.MethodPost, c.endpoint(CollectorsEndpoint+"/"+url.PathEscape(name)+"/refresh", nil), nil, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+// Package client is a Go client for the iquota-server API. Requests are
+// authenticated with Kerberos (SPNEGO) and optionally a session cookie issued
+// by the server. See openapi.yaml in cmd/iquota-server for the API.
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+)
+
+// Default max time to wait before retrying a rate limited request
+const DefaultMaxRetryWait = 5 * time.Second
+
+var (
+	ErrUnauthorized = errors.New("not authorized")
+)
+
+// Returned when the iquota server rate limits a request
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func newRateLimitError(res *http.Response) *RateLimitError {
+	err := &RateLimitError{RetryAfter: time.Second}
+	if secs, perr := strconv.Atoi(res.Header.Get("Retry-After")); perr == nil && secs > 0 {
+		err.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	return err
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Too many requests to the iquota server. Please try again in %s", e.RetryAfter)
+}
+
+// Returned when the iquota server rejects a request. Message is the error
+// sent by the server if any.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Message) > 0 {
+		return e.Message
+	}
+
+	return fmt.Sprintf("Request failed with HTTP status code: %d", e.StatusCode)
+}
+
+// Client for the iquota-server API
+type Client struct {
+	// Base URL of the iquota server
+	URL string
+
+	// CA certificates used to verify the server. Defaults to the system pool
+	RootCAs *x509.CertPool
+
+	// Skip verifying the server certificate
+	InsecureSkipVerify bool
+
+	// Session cookie jar. If nil every request is authenticated with Kerberos
+	Jar *SessionJar
+
+	// Max time to wait before retrying a rate limited request once. Zero
+	// disables retries
+	MaxRetryWait time.Duration
+
+	// Timeout for each request. Zero means no timeout. Event streams are not
+	// subject to the timeout
+	Timeout time.Duration
+
+	once sync.Once
+	tr   http.RoundTripper
+}
+
+// New returns a client for the iquota server at url
+func New(url string) *Client {
+	return &Client{
+		URL:          strings.TrimSuffix(url, "/"),
+		MaxRetryWait: DefaultMaxRetryWait,
+	}
+}
+
+func (c *Client) transport() http.RoundTripper {
+	c.once.Do(func() {
+		c.tr = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				RootCAs:            c.RootCAs,
+				InsecureSkipVerify: c.InsecureSkipVerify,
+			},
+		}
+	})
+
+	return c.tr
+}
+
+func (c *Client) endpoint(path string, params url.Values) string {
+	if len(params) == 0 {
+		return c.URL + path
+	}
+
+	return c.URL + path + "?" + params.Encode()
+}
+
+// Send request to the iquota server. If we have a session cookie from a
+// previous request try that first and fall back to Kerberos authentication if
+// the session was rejected.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	return c.doTimeout(req, c.Timeout)
+}
+
+func (c *Client) doTimeout(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	tr := c.transport()
+
+	var jar http.CookieJar
+	if c.Jar != nil {
+		jar = c.Jar
+	}
+
+	if c.Jar != nil && c.Jar.HasSession(req.URL) {
+		client := &http.Client{Transport: tr, Jar: jar, Timeout: timeout}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		// Server sends a negotiate challenge if the session is invalid or
+		// expired
+		if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") != negotiateHeader {
+			return res, nil
+		}
+
+		res.Body.Close()
+		c.Jar.Clear(req.URL)
+		logrus.Info("Session rejected, falling back to Kerberos")
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	client := &http.Client{Transport: &NegotiateTransport{Next: tr}, Jar: jar, Timeout: timeout}
+	return client.Do(req)
+}
+
+// Returns the error message sent by the server in the response body
+func errorMessage(res *http.Response) string {
+	var body struct {
+		Message string `json:"message"`
+	}
+
+	rawJson, err := ioutil.ReadAll(res.Body)
+	if err != nil || json.Unmarshal(rawJson, &body) != nil {
+		return ""
+	}
+
+	return body.Message
+}
+
+// Returns the error for an unsuccessful response from the iquota server
+func checkResponse(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		return nil
+	case http.StatusTooManyRequests:
+		return newRateLimitError(res)
+	case http.StatusNotFound:
+		return iquota.ErrNotFound
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	}
+
+	return &APIError{StatusCode: res.StatusCode, Message: errorMessage(res)}
+}
+
+// Send a request to the iquota server with in encoded as the JSON body and
+// decode the JSON response into out. Rate limited requests are retried once
+// if the server asks us to wait less than MaxRetryWait.
+func (c *Client) requestJSON(method, url string, in, out interface{}) error {
+	err := c.doJSON(method, url, in, out)
+
+	var rerr *RateLimitError
+	if errors.As(err, &rerr) && rerr.RetryAfter <= c.MaxRetryWait {
+		logrus.Infof("Rate limited, retrying in %s", rerr.RetryAfter)
+		time.Sleep(rerr.RetryAfter)
+		err = c.doJSON(method, url, in, out)
+	}
+
+	return err
+}
+
+func (c *Client) doJSON(method, url string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	err = checkResponse(res)
+	if err != nil {
+		return err
+	}
+
+	rawJson, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(rawJson, out)
+}
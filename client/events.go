@@ -0,0 +1,77 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ubccr/iquota"
+)
+
+const (
+	EventsEndpoint = "/events"
+)
+
+// Events follows the stream of changes to the quotas matching q and calls fn
+// for each event. Returns when the server closes the stream, the connection
+// fails or fn returns an error. Callers should reconnect to keep following.
+func (c *Client) Events(q QuotaQuery, fn func(*iquota.QuotaEvent) error) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint(EventsEndpoint, q.params()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := c.doTimeout(req, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	err = checkResponse(res)
+	if err != nil {
+		return err
+	}
+
+	// Parse the event stream. Only the data field is needed since the event
+	// type is also in the JSON payload
+	var data strings.Builder
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if len(line) == 0 {
+			if data.Len() == 0 {
+				continue
+			}
+
+			event := &iquota.QuotaEvent{}
+			err := json.Unmarshal([]byte(data.String()), event)
+			if err != nil {
+				return fmt.Errorf("Invalid quota event: %w", err)
+			}
+			data.Reset()
+
+			err = fn(event)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "data:") {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	return scanner.Err()
+}
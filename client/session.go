@@ -0,0 +1,142 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SessionCookieName = "iquota_session"
+)
+
+// SessionJar is a per-user cookie jar persisted to disk so the session cookie
+// issued by the iquota server can be re-used across runs. Cookies are stored
+// per host. Implements the http.CookieJar interface
+type SessionJar struct {
+	path    string
+	mu      sync.Mutex
+	cookies map[string][]*http.Cookie
+}
+
+// DefaultSessionFile returns the default location of the session cookie jar
+// in the user's cache dir
+func DefaultSessionFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "iquota", "session.json")
+}
+
+// NewSessionJar loads the cookie jar from path. If path is empty cookies are
+// only kept in memory
+func NewSessionJar(path string) *SessionJar {
+	jar := &SessionJar{path: path, cookies: make(map[string][]*http.Cookie)}
+	if len(path) == 0 {
+		return jar
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return jar
+	}
+
+	err = json.Unmarshal(data, &jar.cookies)
+	if err != nil {
+		logrus.Infof("Ignoring invalid session file %s: %s", path, err)
+		jar.cookies = make(map[string][]*http.Cookie)
+	}
+
+	return jar
+}
+
+func (j *SessionJar) save() {
+	if len(j.path) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(j.cookies)
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(j.path), 0700)
+	if err != nil {
+		logrus.Infof("Failed to create session dir: %s", err)
+		return
+	}
+
+	tmp := j.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		logrus.Infof("Failed to save session file: %s", err)
+	}
+}
+
+func (j *SessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, c := range cookies {
+		if c.Name != SessionCookieName {
+			continue
+		}
+
+		if c.MaxAge > 0 {
+			c.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+
+		if c.MaxAge < 0 || len(c.Value) == 0 {
+			delete(j.cookies, u.Host)
+		} else {
+			j.cookies[u.Host] = []*http.Cookie{c}
+		}
+		j.save()
+	}
+}
+
+func (j *SessionJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var valid []*http.Cookie
+	for _, c := range j.cookies[u.Host] {
+		if c.Expires.IsZero() || c.Expires.After(time.Now()) {
+			valid = append(valid, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+
+	return valid
+}
+
+// Returns true if there is an unexpired session cookie for u
+func (j *SessionJar) HasSession(u *url.URL) bool {
+	return len(j.Cookies(u)) > 0
+}
+
+// Remove the session for u. Called when the server rejects the cookie
+func (j *SessionJar) Clear(u *url.URL) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.cookies[u.Host]; ok {
+		delete(j.cookies, u.Host)
+		j.save()
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ubccr/kerby"
+)
+
+const (
+	negotiateHeader = "Negotiate"
+)
+
+// NegotiateTransport is an HTTP client transport that authenticates requests
+// using SPNEGO. Unlike khttp.Transport, error responses sent without a negotiate reply (for
+// example when the server rate limits a request before authenticating it) are
+// returned to the caller instead of failing with an error.
+type NegotiateTransport struct {
+	Next http.RoundTripper
+}
+
+func (t *NegotiateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host, _, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host = req.URL.Host
+	}
+
+	kc := new(kerby.KerbClient)
+	err = kc.Init("HTTP@"+host, "")
+	if err != nil {
+		return nil, err
+	}
+	defer kc.Clean()
+
+	err = kc.Step("")
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", negotiateHeader+" "+kc.Response())
+
+	res, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	authReply := strings.Split(res.Header.Get("WWW-Authenticate"), " ")
+	if len(authReply) != 2 || !strings.EqualFold(authReply[0], negotiateHeader) {
+		if res.StatusCode < 300 {
+			res.Body.Close()
+			return nil, errors.New("server replied with invalid www-authenticate header")
+		}
+
+		return res, nil
+	}
+
+	// Authenticate the reply from the server
+	err = kc.Step(authReply[1])
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -18,6 +18,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
@@ -47,9 +48,14 @@ var (
 
 	prefix = kingpin.Flag(
 		"prefix",
-		"Path prefix for mount point of panfs",
+		"Path prefix for mount point of panfs if no route in iquota.yaml covers a volume",
 	).Default("/panasas").Envar("IPANFS_PREFIX").String()
 
+	backendName = kingpin.Flag(
+		"backend",
+		"Backend name of panfs in the iquota.yaml routing table",
+	).Default("panfs").Envar("IPANFS_BACKEND").String()
+
 	address = kingpin.Flag(
 		"address",
 		"Address of panfs server",
@@ -249,13 +255,18 @@ func main() {
 		log.SetLevel(log.WarnLevel)
 	}
 
+	start := time.Now()
+	cache := &iquota.Cache{Expire: *expire}
+
 	report, err := fetchQuotaReport()
 	if err != nil {
+		cache.RecordCommandRun("panfs", start, 0, err)
 		log.Fatalf("Failed to fetch quota report from panfs: %s", err)
 	}
 
 	volumes, err := fetchVolumes()
 	if err != nil {
+		cache.RecordCommandRun("panfs", start, 0, err)
 		log.Fatalf("Failed to fetch volumes: %s", err)
 	}
 	if *noop {
@@ -266,15 +277,25 @@ func main() {
 		os.Exit(0)
 	}
 
+	routes, err := iquota.NewRoutes(viper.GetViper(), "routes")
+	if err != nil {
+		cache.RecordCommandRun("panfs", start, 0, err)
+		log.Fatalf("Failed to read routing table: %s", err)
+	}
+
 	reader := bytes.NewReader(report.Bytes())
 	gquotas, err := parseGroupQuotas(reader)
 	if err != nil {
+		cache.RecordCommandRun("panfs", start, 0, err)
 		log.Fatalf("Failed to parse group quota report from panfs: %s", err)
 	}
 
-	cache := &iquota.Cache{Expire: *expire}
+	count := 0
 	for _, v := range volumes {
-		path := fmt.Sprintf("%s%s", *prefix, v.Name)
+		path, ok := routes.FromStorage(*backendName, v.Name)
+		if !ok {
+			path = fmt.Sprintf("%s%s", *prefix, v.Name)
+		}
 
 		hard, err := humanize.ParseBytes(v.Hard + " GB")
 		if err != nil {
@@ -294,6 +315,7 @@ func main() {
 			HardLimit:   int(hard),
 			SoftLimit:   int(soft),
 			Used:        int(used),
+			Source:      "panfs",
 		}
 
 		q, ok := gquotas[v.Name]
@@ -308,6 +330,19 @@ func main() {
 				"path":  path,
 				"error": err,
 			}).Error("Failed to set panfs directory quota cache in redis")
+			continue
+		}
+
+		err = cache.AddHistory(iq, start)
+		if err != nil {
+			log.Errorf("Failed to record quota history for %s: %s", path, err)
 		}
+
+		count++
+	}
+
+	err = cache.RecordCommandRun("panfs", start, count, nil)
+	if err != nil {
+		log.Errorf("Failed to record panfs collector status: %s", err)
 	}
 }
@@ -0,0 +1,209 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+)
+
+// Apply new limits to path through backend and refresh the cache. Any
+// temporary increase on the path is updated to revert to the new limits.
+func applyQuota(backend iquota.Backend, path string, limits *iquota.Limits) error {
+	err := backend.SetQuota(path, limits)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"path":    path,
+			"backend": backend.Name(),
+		}).Error("Failed to set quota")
+		return err
+	}
+
+	refreshQuotaCache(backend, path)
+
+	cache := Conf().Cache()
+	if t, err := cache.GetTempIncrease(path); err == nil {
+		if quota, err := backend.GetQuota(path); err == nil {
+			t.Original = quota.QuotaLimits()
+			cache.SetTempIncrease(t)
+		}
+	}
+
+	return nil
+}
+
+// Parse the path and dry_run query params and find the backend for path.
+// Returns the current quota which is nil if path has no quota.
+func (h *Handler) quotaChange(c echo.Context) (*iquota.QuotaChange, iquota.Backend, error) {
+	path := c.QueryParam("path")
+	if len(path) == 0 || !filepath.IsAbs(path) {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Path must be absolute")
+	}
+	path = filepath.Clean(path)
+
+	change := &iquota.QuotaChange{Path: path}
+
+	if d := c.QueryParam("dry_run"); len(d) > 0 {
+		var err error
+		change.DryRun, err = strconv.ParseBool(d)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid dry_run")
+		}
+	}
+
+	backend, err := h.backends.ForPath(path)
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Quota changes are not supported for path")
+	}
+	change.Backend = backend.Name()
+
+	change.Current, err = backend.GetQuota(path)
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"path":    path,
+			"backend": backend.Name(),
+		}).Error("Failed to fetch quota from storage backend")
+
+		return nil, nil, echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch current quota")
+	}
+
+	return change, backend, nil
+}
+
+// Create or update the quota on a directory
+func (h *Handler) SetQuota(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	limits := &iquota.Limits{}
+	if err := c.Bind(limits); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quota limits")
+	}
+
+	if limits.SoftLimit <= 0 || limits.HardLimit < 0 || limits.SoftLimitInodes < 0 || limits.HardLimitInodes < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quota limit")
+	}
+
+	if (limits.HardLimit > 0 && limits.HardLimit < limits.SoftLimit) ||
+		(limits.HardLimitInodes > 0 && limits.HardLimitInodes < limits.SoftLimitInodes) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Hard limit must be larger than soft limit")
+	}
+
+	change, backend, err := h.quotaChange(c)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("User %s setting quota for %s", user.UID, change.Path)
+
+	change.Action = iquota.QuotaUpdate
+	if change.Current == nil {
+		change.Action = iquota.QuotaCreate
+		if limits.SoftLimitInodes == 0 {
+			limits.SoftLimitInodes = iquota.DefaultFilesLimit
+		}
+	} else if limits.SoftLimitInodes == 0 {
+		// Keep the current file limits
+		limits.SoftLimitInodes = change.Current.SoftLimitInodes
+		limits.HardLimitInodes = change.Current.HardLimitInodes
+	}
+
+	// Report and set the limits the backend will actually use
+	limits = iquota.ResolveLimits(backend, limits)
+	change.Limits = limits
+
+	if change.DryRun {
+		return c.JSON(http.StatusOK, change)
+	}
+
+	err = applyQuota(backend, change.Path, limits)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to set quota")
+	}
+
+	log.WithFields(log.Fields{
+		"path":    change.Path,
+		"action":  change.Action,
+		"backend": change.Backend,
+		"user":    user.UID,
+	}).Warn("Quota set by admin")
+
+	return c.JSON(http.StatusOK, change)
+}
+
+// Delete the quota on a directory
+func (h *Handler) DeleteQuota(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	change, backend, err := h.quotaChange(c)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("User %s deleting quota for %s", user.UID, change.Path)
+
+	if change.Current == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No quota found for path")
+	}
+	change.Action = iquota.QuotaDelete
+
+	if change.DryRun {
+		return c.JSON(http.StatusOK, change)
+	}
+
+	err = backend.DeleteQuota(change.Path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"path":    change.Path,
+			"backend": change.Backend,
+		}).Error("Failed to delete quota")
+
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to delete quota")
+	}
+
+	cache := Conf().Cache()
+	if err := cache.DeleteDirectoryQuotaCache(change.Path); err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": change.Path,
+		}).Warn("Failed to remove cached quota")
+	}
+
+	// Nothing left to revert
+	cache.DeleteTempIncrease(change.Path)
+
+	log.WithFields(log.Fields{
+		"path":    change.Path,
+		"action":  change.Action,
+		"backend": change.Backend,
+		"user":    user.UID,
+	}).Warn("Quota deleted by admin")
+
+	return c.JSON(http.StatusOK, change)
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/ubccr/iquota"
+)
+
+func TestSetQuotaLimits(t *testing.T) {
+	live, backend := newTestLiveQuotas(t)
+	close(backend.release)
+	backend.quotas = map[string]bool{"/test/grp-a": true}
+	Conf().Admins = []string{"admin"}
+
+	h := &Handler{backends: live.backends}
+
+	tests := []struct {
+		path   string
+		body   string
+		action string
+		want   iquota.Limits
+	}{
+		// New quotas get the default file limit and padded hard limits
+		{"/test/grp-new", `{"soft_limit": 100}`, iquota.QuotaCreate,
+			iquota.Limits{SoftLimit: 100, HardLimit: 110, SoftLimitInodes: iquota.DefaultFilesLimit, HardLimitInodes: iquota.DefaultFilesLimit + 10}},
+		{"/test/grp-new", `{"soft_limit": 100, "hard_limit": 200, "soft_limit_inodes": 50}`, iquota.QuotaCreate,
+			iquota.Limits{SoftLimit: 100, HardLimit: 200, SoftLimitInodes: 50, HardLimitInodes: 60}},
+		// Updates keep the current file limits
+		{"/test/grp-a", `{"soft_limit": 100}`, iquota.QuotaUpdate,
+			iquota.Limits{SoftLimit: 100, HardLimit: 110, SoftLimitInodes: 0, HardLimitInodes: 10}},
+	}
+
+	for _, test := range tests {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/quota?dry_run=true&path="+test.path, strings.NewReader(test.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user", &User{UID: "admin"})
+
+		if err := h.SetQuota(c); err != nil {
+			t.Fatalf("%s %s: %s", test.path, test.body, err)
+		}
+
+		var change iquota.QuotaChange
+		if err := json.Unmarshal(rec.Body.Bytes(), &change); err != nil {
+			t.Fatal(err)
+		}
+
+		if change.Action != test.action {
+			t.Errorf("%s %s: expected action %s got %s", test.path, test.body, test.action, change.Action)
+		}
+		if change.Limits == nil || *change.Limits != test.want {
+			t.Errorf("%s %s: expected limits %+v got %+v", test.path, test.body, test.want, change.Limits)
+		}
+	}
+}
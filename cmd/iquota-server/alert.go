@@ -0,0 +1,355 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+const (
+	// Number of attempts to deliver an alert to a webhook
+	webhookAttempts = 3
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	"bytes": func(v int) string {
+		return humanize.Bytes(uint64(v))
+	},
+	"comma": func(v int) string {
+		return humanize.Comma(int64(v))
+	},
+}
+
+// Webhook alerts are delivered to. The JSON body is rendered from Template
+// with the alert as data or is the alert itself if no template is set.
+type Webhook struct {
+	Name     string
+	URL      string            `mapstructure:"url"`
+	Headers  map[string]string `mapstructure:"headers"`
+	Template string            `mapstructure:"template"`
+	Timeout  int               `mapstructure:"timeout"`
+
+	tmpl *template.Template
+}
+
+// Returns the JSON body sent for alert
+func (w *Webhook) body(alert *iquota.Alert) ([]byte, error) {
+	if w.tmpl == nil {
+		return json.Marshal(alert)
+	}
+
+	var buf bytes.Buffer
+	err := w.tmpl.Execute(&buf, alert)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("Template for webhook %s produced invalid JSON", w.Name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Send alert to the webhook
+func (w *Webhook) Send(alert *iquota.Alert) error {
+	body, err := w.body(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: time.Duration(w.Timeout) * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Webhook %s failed with HTTP status code: %d", w.Name, res.StatusCode)
+	}
+
+	return nil
+}
+
+// AlertEngine periodically evaluates the cached quotas against the alert rules
+// and delivers new and resolved alerts to webhooks. Active alerts are kept in
+// redis so each alert is only delivered once across restarts and servers.
+type AlertEngine struct {
+	rules    []*iquota.AlertRule
+	webhooks map[string]*Webhook
+
+	// How long a quota must be missing from the cache before its alerts
+	// resolve
+	missing time.Duration
+}
+
+// NewAlertEngine loads the alert rules and webhooks from the alerts section
+// of v
+func NewAlertEngine(v *viper.Viper) (*AlertEngine, error) {
+	a := &AlertEngine{}
+
+	// A quota can drop out of the cache for a while, for example when its
+	// collector fails a run, so by default wait out the longest collector
+	// interval or the cache expiry if no collectors are hosted here
+	missing := v.GetInt("alerts.missing")
+	if missing == 0 {
+		missing = v.GetInt("cache_expire")
+		for name := range v.GetStringMap("collectors") {
+			if interval := v.GetInt("collectors." + name + ".interval"); interval > missing {
+				missing = interval
+			}
+		}
+	}
+	if missing < 0 {
+		return nil, errors.New("Invalid alerts missing must be positive")
+	}
+	a.missing = time.Duration(missing) * time.Second
+
+	err := v.UnmarshalKey("alerts.rules", &a.rules)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid alert rules - %s", err)
+	}
+
+	err = v.UnmarshalKey("alerts.webhooks", &a.webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid alert webhooks - %s", err)
+	}
+
+	for name, w := range a.webhooks {
+		w.Name = name
+		if len(w.URL) == 0 {
+			return nil, fmt.Errorf("Missing url for webhook %s", name)
+		}
+
+		if w.Timeout <= 0 {
+			w.Timeout = 10
+		}
+
+		if len(w.Template) > 0 {
+			w.tmpl, err = template.New(name).Funcs(templateFuncs).Parse(w.Template)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid template for webhook %s - %s", name, err)
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, r := range a.rules {
+		err := r.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if names[r.Name] {
+			return nil, fmt.Errorf("Duplicate alert rule %s", r.Name)
+		}
+		names[r.Name] = true
+
+		for _, hook := range r.Webhooks {
+			if _, ok := a.webhooks[hook]; !ok {
+				return nil, fmt.Errorf("Unknown webhook %s in alert rule %s", hook, r.Name)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// Returns the webhooks for rule. Rules without webhooks use all of them
+func (a *AlertEngine) ruleWebhooks(rule *iquota.AlertRule) []*Webhook {
+	hooks := make([]*Webhook, 0)
+	if len(rule.Webhooks) == 0 {
+		for _, w := range a.webhooks {
+			hooks = append(hooks, w)
+		}
+		return hooks
+	}
+
+	for _, name := range rule.Webhooks {
+		hooks = append(hooks, a.webhooks[name])
+	}
+
+	return hooks
+}
+
+// Deliver alert to the webhooks of rule, retrying failed deliveries
+func (a *AlertEngine) deliver(rule *iquota.AlertRule, alert *iquota.Alert) {
+	log.WithFields(log.Fields{
+		"rule":    alert.Rule,
+		"path":    alert.Path,
+		"status":  alert.Status,
+		"percent": alert.Percent,
+	}).Warn("Quota alert")
+
+	for _, w := range a.ruleWebhooks(rule) {
+		var err error
+		for i := 0; i < webhookAttempts; i++ {
+			if i > 0 {
+				time.Sleep(time.Duration(i) * time.Second)
+			}
+
+			err = w.Send(alert)
+			if err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"webhook": w.Name,
+				"rule":    alert.Rule,
+				"path":    alert.Path,
+			}).Error("Failed to deliver alert")
+		}
+	}
+}
+
+// Evaluate all cached quotas against the alert rules
+func (a *AlertEngine) check(interval time.Duration) {
+	cache := Conf().Cache()
+
+	// Only one server should send alerts when running more than one. The
+	// lock expires just before the next run
+	_, ok, err := cache.AcquireLock("alerts", interval*9/10)
+	if err != nil || !ok {
+		return
+	}
+
+	quotas, err := cache.SearchDirectoryQuotaCache("")
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch quotas for alerts")
+		return
+	}
+
+	alerts, err := cache.ListAlerts()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch active alerts")
+		return
+	}
+
+	active := make(map[string]*iquota.Alert, len(alerts))
+	for _, alert := range alerts {
+		active[alert.Rule+":"+alert.Path] = alert
+	}
+
+	now := time.Now()
+	rules := make(map[string]*iquota.AlertRule, len(a.rules))
+	for _, rule := range a.rules {
+		rules[rule.Name] = rule
+		for _, q := range quotas {
+			if !rule.Matches(q.Path) {
+				continue
+			}
+
+			key := rule.Name + ":" + q.Path
+			prev := active[key]
+			delete(active, key)
+
+			alert := rule.Evaluate(q, prev, now)
+			if alert == nil {
+				// The quota is back in the cache
+				if prev != nil && prev.Missing != nil {
+					prev.Missing = nil
+					cache.SetAlert(prev)
+				}
+				continue
+			}
+
+			if alert.Status == iquota.AlertFiring {
+				err = cache.SetAlert(alert)
+			} else {
+				err = cache.DeleteAlert(alert.Rule, alert.Path)
+			}
+			if err != nil {
+				continue
+			}
+
+			a.deliver(rule, alert)
+		}
+	}
+
+	// Anything left is for a quota no longer in the cache, a path the rule
+	// no longer covers or a rule that was removed
+	for _, alert := range active {
+		rule, ok := rules[alert.Rule]
+		if ok && rule.Matches(alert.Path) {
+			missed := alert.Missing == nil
+			if !alert.Gone(now, a.missing) {
+				if missed {
+					cache.SetAlert(alert)
+				}
+				continue
+			}
+		}
+
+		if cache.DeleteAlert(alert.Rule, alert.Path) != nil {
+			continue
+		}
+
+		if !ok {
+			log.WithFields(log.Fields{
+				"rule": alert.Rule,
+				"path": alert.Path,
+			}).Info("Removed alert for deleted rule")
+			continue
+		}
+
+		a.deliver(rule, alert.Resolve(now))
+	}
+}
+
+// Run evaluates the alert rules every alerts.interval seconds until done is
+// closed
+func (a *AlertEngine) Run(done <-chan struct{}) {
+	interval := time.Duration(viper.GetInt("alerts.interval")) * time.Second
+	if len(a.rules) == 0 || interval <= 0 {
+		log.Info("Quota alerts disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.check(interval)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	AuditSyslog = "syslog"
+
+	AuditAllowed = "allowed"
+	AuditDenied  = "denied"
+	AuditError   = "error"
+)
+
+var (
+	// Endpoints that are always audited as they reveal other users' data to
+	// admins or change quotas
+	auditEndpoints = map[string]bool{
+		"/collectors":           true,
+		"/export":               true,
+		"/ondemand":             true,
+		"/over":                 true,
+		"/requests":             true,
+		"/requests/:id":         true,
+		"/requests/:id/approve": true,
+		"/requests/:id/deny":    true,
+		"/temp":                 true,
+	}
+)
+
+// Structured audit logger for admin and cross-user queries. Entries are
+// written as JSON lines separate from the server log.
+type AuditLogger struct {
+	log *logrus.Logger
+}
+
+// NewAuditLogger creates an audit logger from the audit config. Returns nil if
+// auditing is not enabled.
+func NewAuditLogger() (*AuditLogger, error) {
+	dest := viper.GetString("audit.file")
+	if len(dest) == 0 {
+		return nil, nil
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.InfoLevel)
+	logger.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat: time.RFC3339,
+		FieldMap: logrus.FieldMap{
+			logrus.FieldKeyMsg: "event",
+		},
+	})
+
+	if dest == AuditSyslog {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "iquota-server")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to connect to syslog for audit log: %s", err)
+		}
+		logger.SetOutput(w)
+	} else {
+		if finfo, err := os.Stat(dest); err == nil && finfo.IsDir() {
+			return nil, fmt.Errorf("Audit log file is a directory: %s", dest)
+		}
+
+		logger.SetOutput(&lumberjack.Logger{
+			Filename:   dest,
+			MaxSize:    viper.GetInt("audit.max_size"),
+			MaxBackups: viper.GetInt("audit.max_backups"),
+			MaxAge:     viper.GetInt("audit.max_age"),
+			Compress:   viper.GetBool("audit.compress"),
+		})
+	}
+
+	return &AuditLogger{log: logger}, nil
+}
+
+// Returns true if the request should be audited. This includes all admin
+// endpoints, any write and any query for a user, group or path other than the
+// callers own home directory.
+func auditRequired(c echo.Context, user *User) bool {
+	if auditEndpoints[c.Path()] || c.Request().Method != http.MethodGet {
+		return true
+	}
+
+	if u := c.QueryParam("user"); len(u) > 0 && u != user.UID {
+		return true
+	}
+
+	return len(c.QueryParam("group")) > 0 || len(c.QueryParam("path")) > 0
+}
+
+// Record an audit log entry
+func (a *AuditLogger) Record(c echo.Context, user *User, status int) {
+	result := AuditAllowed
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		result = AuditDenied
+	} else if status >= 300 && status != http.StatusNotFound {
+		result = AuditError
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"principal":    user.Principal,
+		"uid":          user.UID,
+		"groups":       user.Groups,
+		"admin":        user.IsAdmin(),
+		"endpoint":     c.Path(),
+		"method":       c.Request().Method,
+		"target_user":  c.QueryParam("user"),
+		"target_group": c.QueryParam("group"),
+		"target_path":  c.QueryParam("path"),
+		"result":       result,
+		"status":       status,
+		"source_ip":    c.RealIP(),
+	}).Info("query")
+}
+
+// Middleware to audit admin and cross-user queries. Must run after
+// KerbAuthRequired.
+func (a *AuditLogger) Middleware() echo.MiddlewareFunc {
+	if a == nil {
+		return passThrough
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			user, ok := c.Get("user").(*User)
+			if !ok || !auditRequired(c, user) {
+				return err
+			}
+
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+
+			a.Record(c, user, status)
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+const (
+	// Default timeout in seconds for a collector run
+	DefaultCollectorTimeout = 300
+)
+
+var (
+	errCollectorRunning = errors.New("Collector is already running")
+)
+
+// Quota collector hosted by iquota-server. Collectors either fetch all quotas
+// from a storage backend that supports it (vast) or run an external collector
+// command such as ipanfs.
+type Collector struct {
+	Name string
+
+	// Name of the storage backend to fetch quotas from
+	Backend string `mapstructure:"backend"`
+
+	// Command and arguments to run
+	Command []string `mapstructure:"command"`
+
+	// Extra environment for the command as KEY=VALUE
+	Env []string `mapstructure:"env"`
+
+	// File of KEY=VALUE lines to add to the command environment
+	EnvFile string `mapstructure:"env_file"`
+
+	// Seconds between runs. If zero the collector only runs on demand
+	Interval int `mapstructure:"interval"`
+
+	// Max random seconds added to each interval so collectors on the same
+	// schedule don't all hit storage at once
+	Jitter int `mapstructure:"jitter"`
+
+	// Seconds before a run is cancelled
+	Timeout int `mapstructure:"timeout"`
+
+	// Seconds quotas fetched from a backend stay cached. Defaults to twice
+	// the interval plus the timeout or cache_expire if greater
+	Expire int `mapstructure:"expire"`
+
+	collector iquota.Collector
+	env       []string
+	trigger   chan struct{}
+
+	mu      sync.Mutex
+	running bool
+	started time.Time
+	next    time.Time
+}
+
+// Reads the KEY=VALUE lines of an env file as used with cron. Blank lines,
+// comments and export prefixes are skipped and values may be quoted.
+func readEnvFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("Invalid line in env file %s: %s", file, line)
+		}
+
+		value := parts[1]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		env = append(env, strings.TrimSpace(parts[0])+"="+value)
+	}
+
+	return env, scanner.Err()
+}
+
+// Type of the collector
+func (c *Collector) Type() string {
+	if c.collector != nil {
+		return iquota.CollectorBackend
+	}
+
+	return iquota.CollectorCommand
+}
+
+func (c *Collector) timeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *Collector) expire() int {
+	expire := c.Expire
+	if expire == 0 {
+		expire = 2*c.Interval + c.Timeout
+	}
+
+	if conf := Conf(); expire < conf.CacheExpire {
+		expire = conf.CacheExpire
+	}
+
+	return expire
+}
+
+// Returns the time to wait before the next scheduled run
+func (c *Collector) delay(first bool) time.Duration {
+	wait := time.Duration(c.Interval) * time.Second
+	if first {
+		wait = 0
+	}
+
+	if c.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(c.Jitter) * int64(time.Second)))
+	}
+
+	return wait
+}
+
+// Runs the collector command. The run is recorded by the scheduler, not the
+// command, and the number of quotas cached is read from the last line the
+// command prints to stdout.
+func (c *Collector) runCommand(ctx context.Context) (int, error) {
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Env = append(os.Environ(), c.env...)
+
+	// Tells ivast and ipanfs to leave recording the run to us
+	cmd.Env = append(cmd.Env, iquota.CollectorEnv+"="+c.Name)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Don't wait forever on children of a killed command holding the output
+	// open
+	cmd.WaitDelay = 10 * time.Second
+
+	err := cmd.Run()
+	if err != nil {
+		if msg := lastLine(stderr.String()); len(msg) > 0 {
+			return 0, fmt.Errorf("%s: %s", err, msg)
+		}
+		return 0, err
+	}
+
+	// The command prints the number of quotas it cached last, if at all
+	count, _ := strconv.Atoi(lastLine(stdout.String()))
+
+	return count, nil
+}
+
+// Returns the last line of out
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Fetches all quotas from the backend and caches them
+func (c *Collector) runBackend(ctx context.Context, start time.Time) (int, error) {
+	quotas, err := c.collector.FetchAllQuotas(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cache := Conf().Cache()
+	cache.Expire = c.expire()
+
+	return cache.CacheQuotas(quotas, start), nil
+}
+
+func (c *Collector) setRunning(running bool, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running = running
+	if running {
+		c.started = t
+	}
+}
+
+func (c *Collector) setNext(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.next = t
+}
+
+// Run the collector once. Scheduled runs are skipped if another server ran
+// the collector within the last half interval. A lock in redis ensures the
+// collector never runs more than once at a time across all servers.
+func (c *Collector) run(scheduled bool) {
+	cache := Conf().Cache()
+
+	if scheduled {
+		status, err := cache.GetCollectorStatus(c.Name)
+		if err == nil && time.Since(status.LastRun) < time.Duration(c.Interval)*time.Second/2 {
+			log.Infof("Collector %s ran recently, skipping", c.Name)
+			return
+		}
+	}
+
+	// The lock outlives the timeout in case the server dies mid run
+	lock := "collector:" + c.Name
+	token, ok, err := cache.AcquireLock(lock, c.timeout()+time.Minute)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":       err,
+			"collector": c.Name,
+		}).Error("Failed to lock collector")
+		return
+	}
+	if !ok {
+		log.Infof("Collector %s already running, skipping", c.Name)
+		return
+	}
+	defer cache.ReleaseLock(lock, token)
+
+	start := time.Now()
+	c.setRunning(true, start)
+	defer c.setRunning(false, start)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+
+	var count int
+	if c.collector != nil {
+		count, err = c.runBackend(ctx, start)
+	} else {
+		count, err = c.runCommand(ctx)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("Timed out after %s", c.timeout())
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":       err,
+			"collector": c.Name,
+		}).Error("Collector failed")
+	} else {
+		log.WithFields(log.Fields{
+			"collector": c.Name,
+			"count":     count,
+			"duration":  time.Since(start).Seconds(),
+		}).Info("Collector finished")
+	}
+
+	err = cache.RecordCollectorRun(c.Name, start, count, err)
+	if err != nil {
+		log.Errorf("Failed to record %s collector status: %s", c.Name, err)
+	}
+}
+
+// Refresh asks the collector to run now. Returns errCollectorRunning if it is
+// already running.
+func (c *Collector) Refresh() error {
+	c.mu.Lock()
+	running := c.running
+	c.mu.Unlock()
+
+	if running {
+		return errCollectorRunning
+	}
+
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+		// Refresh already pending
+	}
+
+	return nil
+}
+
+// Run the collector on its schedule and when refreshed until done is closed.
+// The first scheduled run happens at startup.
+func (c *Collector) Run(done <-chan struct{}) {
+	first := true
+	for {
+		var timer *time.Timer
+		var scheduled <-chan time.Time
+		if c.Interval > 0 {
+			wait := c.delay(first)
+			c.setNext(time.Now().Add(wait))
+			timer = time.NewTimer(wait)
+			scheduled = timer.C
+		}
+
+		isScheduled := false
+		select {
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-scheduled:
+			isScheduled = true
+		case <-c.trigger:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+
+		first = false
+		c.run(isScheduled)
+	}
+}
+
+// Info returns the current state of the collector with its last status
+func (c *Collector) Info(status *iquota.CollectorStatus) *iquota.CollectorInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info := &iquota.CollectorInfo{
+		Name:     c.Name,
+		Type:     c.Type(),
+		Interval: c.Interval,
+		Jitter:   c.Jitter,
+		Timeout:  c.Timeout,
+		Running:  c.running,
+		Status:   status,
+	}
+
+	if c.running {
+		started := c.started
+		info.Started = &started
+	}
+
+	if c.Interval > 0 && !c.next.IsZero() {
+		next := c.next
+		info.NextRun = &next
+	}
+
+	return info
+}
+
+// CollectorScheduler runs the quota collectors configured in the collectors
+// section
+type CollectorScheduler struct {
+	collectors map[string]*Collector
+}
+
+// NewCollectorScheduler loads the collectors from the collectors section of
+// v. Backend collectors must name a backend in backends that implements
+// iquota.Collector.
+func NewCollectorScheduler(v *viper.Viper, backends *iquota.Backends) (*CollectorScheduler, error) {
+	s := &CollectorScheduler{}
+
+	err := v.UnmarshalKey("collectors", &s.collectors)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid collectors - %s", err)
+	}
+
+	for name, c := range s.collectors {
+		c.Name = name
+		c.trigger = make(chan struct{}, 1)
+
+		if (len(c.Backend) > 0) == (len(c.Command) > 0) {
+			return nil, fmt.Errorf("Collector %s must set one of backend or command", name)
+		}
+
+		if len(c.Backend) > 0 {
+			backend, ok := backends.Get(c.Backend)
+			if !ok {
+				return nil, fmt.Errorf("Unknown backend %s for collector %s", c.Backend, name)
+			}
+
+			c.collector, ok = backend.(iquota.Collector)
+			if !ok {
+				return nil, fmt.Errorf("Backend %s for collector %s can't fetch all quotas, use a command instead", c.Backend, name)
+			}
+		}
+
+		if len(c.EnvFile) > 0 {
+			c.env, err = readEnvFile(c.EnvFile)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read env file for collector %s - %s", name, err)
+			}
+		}
+		c.env = append(c.env, c.Env...)
+
+		if c.Interval < 0 || c.Jitter < 0 || c.Timeout < 0 || c.Expire < 0 {
+			return nil, fmt.Errorf("Invalid settings for collector %s must be positive", name)
+		}
+
+		if c.Timeout == 0 {
+			c.Timeout = DefaultCollectorTimeout
+		}
+	}
+
+	return s, nil
+}
+
+// Get returns the collector with the given name
+func (s *CollectorScheduler) Get(name string) (*Collector, bool) {
+	c, ok := s.collectors[name]
+	return c, ok
+}
+
+// List returns the state of all collectors including those that record their
+// status but are not hosted by this server
+func (s *CollectorScheduler) List() ([]*iquota.CollectorInfo, error) {
+	statuses, err := Conf().Cache().ListCollectorStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*iquota.CollectorStatus, len(statuses))
+	for _, status := range statuses {
+		byName[status.Name] = status
+	}
+
+	collectors := make([]*iquota.CollectorInfo, 0, len(byName))
+	for name, c := range s.collectors {
+		collectors = append(collectors, c.Info(byName[name]))
+		delete(byName, name)
+	}
+
+	for name, status := range byName {
+		collectors = append(collectors, &iquota.CollectorInfo{Name: name, Type: iquota.CollectorExternal, Status: status})
+	}
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].Name < collectors[j].Name
+	})
+
+	return collectors, nil
+}
+
+// Run starts all collectors and runs them until done is closed
+func (s *CollectorScheduler) Run(done <-chan struct{}) {
+	for _, c := range s.collectors {
+		log.Infof("Starting collector %s (%s) every %ds", c.Name, c.Type(), c.Interval)
+		go c.Run(done)
+	}
+}
+
+// List all collectors with their last status (admin only)
+func (h *Handler) Collectors(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	collectors, err := h.collectors.List()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to list collectors")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list collectors")
+	}
+
+	return c.JSON(http.StatusOK, collectors)
+}
+
+// Run a collector now (admin only)
+func (h *Handler) RefreshCollector(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	collector, ok := h.collectors.Get(c.Param("name"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Collector not hosted by this server")
+	}
+
+	err := collector.Refresh()
+	if errors.Is(err, errCollectorRunning) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+
+	log.WithFields(log.Fields{
+		"collector": collector.Name,
+		"user":      user.UID,
+	}).Warn("Collector refresh requested")
+
+	status, err := Conf().Cache().GetCollectorStatus(collector.Name)
+	if err != nil {
+		status = nil
+	}
+
+	return c.JSON(http.StatusAccepted, collector.Info(status))
+}
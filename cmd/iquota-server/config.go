@@ -0,0 +1,242 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+var (
+	config   atomic.Value
+	reloadMu sync.Mutex
+)
+
+// Server settings that can be reloaded at runtime without a restart. Settings
+// not found here (port, bind, keytab, session, shutdown_timeout, rate_limit,
+// audit, routes, backends, collectors, temp_quota, events, alerts, email) are
+// only read once at startup.
+type Config struct {
+	Admins         []string
+	GroupManagers  map[string][]string
+	HomeDir        string
+	GroupDirs      []string
+	OverPercent    float64
+	EnableCache    bool
+	Redis          string
+	CacheExpire    int
+	NegCacheExpire int
+	Cert           string
+	Key            string
+
+	// Query the storage backend on cache misses
+	ReadThrough     bool
+	BackendTimeout  int
+	BreakerFailures int
+	BreakerCooldown int
+
+	certificate *tls.Certificate
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("port", 8080)
+	v.SetDefault("shutdown_timeout", 30)
+	v.SetDefault("home_dir", "/home")
+	v.SetDefault("over_percent", 90)
+	v.SetDefault("enable_cache", false)
+	v.SetDefault("redis", ":6379")
+	v.SetDefault("cache_expire", 500)
+	v.SetDefault("neg_cache_expire", 86400)
+	v.SetDefault("read_through", false)
+	v.SetDefault("backend_timeout", 10)
+	v.SetDefault("breaker_failures", 5)
+	v.SetDefault("breaker_cooldown", 60)
+	v.SetDefault("health_timeout", 5)
+	v.SetDefault("collector_max_age", 3600)
+	v.SetDefault("trust_proxy_headers", false)
+	v.SetDefault("rate_limit.user.rate", 1)
+	v.SetDefault("rate_limit.user.burst", 10)
+	v.SetDefault("rate_limit.ip.rate", 20)
+	v.SetDefault("rate_limit.ip.burst", 100)
+	v.SetDefault("audit.max_size", 100)
+	v.SetDefault("audit.max_backups", 10)
+	v.SetDefault("audit.max_age", 0)
+	v.SetDefault("audit.compress", false)
+	v.SetDefault("session.ttl", 300)
+	v.SetDefault("temp_quota.interval", 300)
+	v.SetDefault("temp_quota.warn_before", 72)
+	v.SetDefault("events.interval", 30)
+	v.SetDefault("alerts.interval", 300)
+	v.SetDefault("email.interval", 3600)
+	v.SetDefault("email.group_recipients", "managers")
+	v.SetDefault("email.throttle", 24)
+	v.SetDefault("email.templates.warning.subject", defaultWarningSubject)
+	v.SetDefault("email.templates.warning.body", defaultWarningBody)
+	v.SetDefault("email.templates.recovery.subject", defaultRecoverySubject)
+	v.SetDefault("email.templates.recovery.body", defaultRecoveryBody)
+}
+
+// Conf returns the current server configuration
+func Conf() *Config {
+	return config.Load().(*Config)
+}
+
+// NewConfig creates a new server config from v. Returns an error if any
+// settings are invalid.
+func NewConfig(v *viper.Viper) (*Config, error) {
+	conf := &Config{
+		Admins:         v.GetStringSlice("admins"),
+		GroupManagers:  v.GetStringMapStringSlice("group_managers"),
+		HomeDir:        filepath.Clean(v.GetString("home_dir")),
+		GroupDirs:      v.GetStringSlice("group_dirs"),
+		OverPercent:    v.GetFloat64("over_percent"),
+		EnableCache:    v.GetBool("enable_cache"),
+		Redis:          v.GetString("redis"),
+		CacheExpire:    v.GetInt("cache_expire"),
+		NegCacheExpire: v.GetInt("neg_cache_expire"),
+		Cert:           v.GetString("cert"),
+		Key:            v.GetString("key"),
+
+		ReadThrough:     v.GetBool("read_through"),
+		BackendTimeout:  v.GetInt("backend_timeout"),
+		BreakerFailures: v.GetInt("breaker_failures"),
+		BreakerCooldown: v.GetInt("breaker_cooldown"),
+	}
+
+	if !filepath.IsAbs(conf.HomeDir) {
+		return nil, fmt.Errorf("Invalid home_dir must be an absolute path: %s", conf.HomeDir)
+	}
+
+	for i, dir := range conf.GroupDirs {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("Invalid group_dirs must be absolute paths: %s", dir)
+		}
+		conf.GroupDirs[i] = filepath.Clean(dir)
+	}
+
+	if conf.OverPercent < 0 {
+		return nil, fmt.Errorf("Invalid over_percent must be positive: %f", conf.OverPercent)
+	}
+
+	if len(conf.Redis) == 0 {
+		return nil, errors.New("Invalid redis address must not be empty")
+	}
+
+	if conf.CacheExpire < 0 || conf.NegCacheExpire < 0 {
+		return nil, errors.New("Invalid cache expire times must be positive")
+	}
+
+	if conf.BackendTimeout <= 0 || conf.BreakerFailures <= 0 || conf.BreakerCooldown < 0 {
+		return nil, errors.New("Invalid backend_timeout, breaker_failures and breaker_cooldown must be positive")
+	}
+
+	if (conf.Cert == "") != (conf.Key == "") {
+		return nil, errors.New("Both cert and key must be set to enable TLS")
+	}
+
+	if conf.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load TLS cert/key: %s", err)
+		}
+		conf.certificate = &cert
+	}
+
+	return conf, nil
+}
+
+// TLSEnabled returns true if a TLS certificate is configured
+func (conf *Config) TLSEnabled() bool {
+	return conf.certificate != nil
+}
+
+// Cache returns a redis cache using the current settings
+func (conf *Config) Cache() *iquota.Cache {
+	return &iquota.Cache{
+		Expire:  conf.CacheExpire,
+		Redis:   conf.Redis,
+		HomeDir: conf.HomeDir,
+	}
+}
+
+// LoadConfig reads the server config from the global viper instance which
+// should have already read in the config file.
+func LoadConfig() error {
+	conf, err := NewConfig(viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	config.Store(conf)
+	return nil
+}
+
+// ReloadConfig re-reads the config file and atomically swaps in the new
+// config. If the new config is invalid the previous config is kept.
+func ReloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(viper.ConfigFileUsed())
+	err := v.ReadInConfig()
+	if err != nil {
+		return fmt.Errorf("Failed reading config file - %s", err)
+	}
+
+	conf, err := NewConfig(v)
+	if err != nil {
+		return err
+	}
+
+	config.Store(conf)
+	log.WithFields(log.Fields{
+		"file": v.ConfigFileUsed(),
+	}).Warn("Reloaded config")
+
+	return nil
+}
+
+func reload(reason string) {
+	err := ReloadConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"reason": reason,
+		}).Error("Invalid config, keeping previous config")
+	}
+}
+
+// WatchConfig reloads the config when the config file changes or the server
+// receives SIGHUP
+func WatchConfig() {
+	w := viper.New()
+	w.SetConfigFile(viper.ConfigFileUsed())
+	w.OnConfigChange(func(e fsnotify.Event) {
+		reload("file changed")
+	})
+	w.WatchConfig()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload("SIGHUP")
+		}
+	}()
+}
@@ -0,0 +1,567 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/smtp"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultWarningSubject = `Quota warning for {{.Quota.Path}}`
+	defaultWarningBody    = `The quota on {{.Quota.Path}} is {{.Status}}.
+
+  Used:  {{bytes .Quota.Used}} of {{bytes .Quota.SoftLimit}} ({{printf "%.0f" .Percent}}% of the soft limit)
+  Files: {{comma .Quota.UsedInodes}} of {{comma .Quota.SoftLimitInodes}}
+{{- if .Quota.GraceExpiration}}
+  Grace period remaining: {{.Quota.GraceExpiration}}
+{{- end}}
+
+Writes will fail once the hard limit is reached. Please remove any files you
+no longer need or request a quota increase with: iquota request
+`
+
+	defaultRecoverySubject = `Quota recovered for {{.Quota.Path}}`
+	defaultRecoveryBody    = `The quota on {{.Quota.Path}} is {{.Status}}.
+
+  Used:  {{bytes .Quota.Used}} of {{bytes .Quota.SoftLimit}} ({{printf "%.0f" .Percent}}% of the soft limit)
+  Files: {{comma .Quota.UsedInodes}} of {{comma .Quota.SoftLimitInodes}}
+`
+)
+
+var (
+	errQuietHours = errors.New("Email notifications paused during quiet hours")
+	errThrottled  = errors.New("All recipients have been sent this notice recently")
+
+	quietHoursRe = regexp.MustCompile(`^(\d{1,2}):(\d{2})\s*-\s*(\d{1,2}):(\d{2})$`)
+
+	// Order of quota levels used to decide if a quota got worse
+	levelRank = map[string]int{
+		iquota.LevelOK:   0,
+		iquota.LevelWarn: 1,
+		iquota.LevelSoft: 2,
+		iquota.LevelHard: 3,
+	}
+
+	levelStatus = map[string]string{
+		iquota.LevelOK:   "back under its limits",
+		iquota.LevelWarn: "nearing its soft limit",
+		iquota.LevelSoft: "over its soft limit",
+		iquota.LevelHard: "over its hard limit",
+	}
+)
+
+// Data passed to the warning and recovery email templates
+type noticeMessage struct {
+	Owner  *Owner
+	Quota  *iquota.Quota
+	Level  string
+	Status string
+
+	// Percent of the soft limit used, the higher of bytes and inodes
+	Percent float64
+}
+
+// Subject and body templates of an email
+type emailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newEmailTemplate(name, subject, body string) (*emailTemplate, error) {
+	t := &emailTemplate{}
+
+	var err error
+	t.subject, err = template.New(name + "-subject").Funcs(templateFuncs).Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s email subject template - %s", name, err)
+	}
+
+	t.body, err = template.New(name + "-body").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s email body template - %s", name, err)
+	}
+
+	return t, nil
+}
+
+func (t *emailTemplate) render(data interface{}) (string, string, error) {
+	var subject, body bytes.Buffer
+
+	err := t.subject.Execute(&subject, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = t.body.Execute(&body, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+// EmailNotifier emails quota warnings to directory owners through an SMTP
+// relay. Owners are warned when a quota reaches the warning percent and again
+// if it goes over the soft or hard limit, and are sent a recovery notice once
+// usage drops back below the clear percent.
+type EmailNotifier struct {
+	// host:port of the SMTP relay. Notifications are disabled if empty
+	Relay string
+
+	// Sender address
+	From string
+
+	// Domain appended to uids to build recipient addresses
+	Domain string
+
+	// Email group managers (from group_managers) or all group members
+	GroupRecipients string
+
+	// Percent of the soft limit to warn at. Defaults to over_percent
+	Percent float64
+
+	// Percent of the soft limit below which a recovery notice is sent
+	Clear float64
+
+	// Min time between notices to the same recipient about the same quota
+	// and level
+	Throttle time.Duration
+
+	// Minutes after midnight when quiet hours start and end. No email is
+	// sent during quiet hours. Disabled if equal
+	QuietStart int
+	QuietEnd   int
+
+	// Write messages to Out instead of sending them and don't record any
+	// state
+	DryRun bool
+	Out    io.Writer
+
+	warning  *emailTemplate
+	recovery *emailTemplate
+
+	// Returns the uids of the members of group
+	members func(group string) ([]string, error)
+
+	// Returns true if a notice may be sent now and holds off notices with the
+	// same key for the throttle period. The token is needed to lift the
+	// throttle again.
+	allow func(key string) (string, bool, error)
+
+	// Lifts the throttle on key after a notice failed to send
+	release func(key, token string) error
+}
+
+// Parses quiet hours given as "HH:MM-HH:MM" into minutes after midnight
+func parseQuietHours(s string) (int, int, error) {
+	m := quietHoursRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, 0, fmt.Errorf("Invalid quiet_hours %q must be HH:MM-HH:MM", s)
+	}
+
+	var parts [4]int
+	for i, p := range m[1:] {
+		parts[i], _ = strconv.Atoi(p)
+	}
+
+	if parts[0] > 23 || parts[2] > 23 || parts[1] > 59 || parts[3] > 59 {
+		return 0, 0, fmt.Errorf("Invalid quiet_hours %q must be HH:MM-HH:MM", s)
+	}
+
+	return parts[0]*60 + parts[1], parts[2]*60 + parts[3], nil
+}
+
+// NewEmailNotifier loads the email notification settings from the email
+// section of v
+func NewEmailNotifier(v *viper.Viper) (*EmailNotifier, error) {
+	n := &EmailNotifier{
+		Relay:           v.GetString("email.relay"),
+		From:            v.GetString("email.from"),
+		Domain:          v.GetString("email.domain"),
+		GroupRecipients: v.GetString("email.group_recipients"),
+		Percent:         v.GetFloat64("email.percent"),
+		Clear:           v.GetFloat64("email.clear"),
+		Throttle:        time.Duration(v.GetFloat64("email.throttle") * float64(time.Hour)),
+		Out:             os.Stdout,
+		members:         FetchGroupMembers,
+	}
+
+	n.allow = func(key string) (string, bool, error) {
+		// The lock is held for the throttle period so it also works across
+		// servers
+		return Conf().Cache().AcquireLock("email:"+key, n.Throttle)
+	}
+	n.release = func(key, token string) error {
+		return Conf().Cache().ReleaseLock("email:"+key, token)
+	}
+
+	if len(n.Relay) > 0 && len(n.From) == 0 {
+		return nil, errors.New("Missing email from address")
+	}
+
+	if n.GroupRecipients != "managers" && n.GroupRecipients != "members" {
+		return nil, fmt.Errorf("Invalid email group_recipients %q must be managers or members", n.GroupRecipients)
+	}
+
+	if n.Percent < 0 || n.Clear < 0 || n.Throttle < 0 {
+		return nil, errors.New("Invalid email percent, clear and throttle must be positive")
+	}
+
+	if n.Percent > 0 && n.Clear > n.Percent {
+		return nil, errors.New("Invalid email clear must not be greater than percent")
+	}
+
+	var err error
+	if quiet := v.GetString("email.quiet_hours"); len(quiet) > 0 {
+		n.QuietStart, n.QuietEnd, err = parseQuietHours(quiet)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n.warning, err = newEmailTemplate("warning", v.GetString("email.templates.warning.subject"), v.GetString("email.templates.warning.body"))
+	if err != nil {
+		return nil, err
+	}
+
+	n.recovery, err = newEmailTemplate("recovery", v.GetString("email.templates.recovery.subject"), v.GetString("email.templates.recovery.body"))
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// Enabled returns true if an SMTP relay is configured
+func (n *EmailNotifier) Enabled() bool {
+	return len(n.Relay) > 0
+}
+
+// Returns true if t falls within quiet hours
+func (n *EmailNotifier) quiet(t time.Time) bool {
+	if n.QuietStart == n.QuietEnd {
+		return false
+	}
+
+	m := t.Hour()*60 + t.Minute()
+	if n.QuietStart < n.QuietEnd {
+		return m >= n.QuietStart && m < n.QuietEnd
+	}
+
+	// Quiet hours span midnight
+	return m >= n.QuietStart || m < n.QuietEnd
+}
+
+// Returns the percents used to warn and to clear a warning
+func (n *EmailNotifier) thresholds() (float64, float64) {
+	percent := n.Percent
+	if percent == 0 {
+		percent = Conf().OverPercent
+	}
+
+	clear := n.Clear
+	if clear == 0 {
+		clear = math.Max(0, percent-iquota.DefaultAlertHysteresis)
+	}
+
+	return percent, clear
+}
+
+// Recipients returns the uids to notify for owner. Users are notified of their
+// home directory. Group directories notify the group managers, or all members
+// if group_recipients is members or the group has no managers configured.
+func (n *EmailNotifier) Recipients(owner *Owner) ([]string, error) {
+	if !owner.Group {
+		return []string{owner.Name}, nil
+	}
+
+	if n.GroupRecipients == "managers" {
+		if managers := Conf().GroupManagers[owner.Name]; len(managers) > 0 {
+			return managers, nil
+		}
+	}
+
+	return n.members(owner.Name)
+}
+
+// Returns the email address of uid
+func (n *EmailNotifier) address(uid string) string {
+	if len(n.Domain) == 0 || strings.Contains(uid, "@") {
+		return uid
+	}
+
+	return uid + "@" + n.Domain
+}
+
+// Send an email to the recipients through the relay or write it to Out in
+// dry run mode
+func (n *EmailNotifier) send(to []string, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&msg, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprint(&msg, "\r\n")
+	fmt.Fprint(&msg, strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if n.DryRun {
+		_, err := fmt.Fprintf(n.Out, "%s\n", strings.ReplaceAll(msg.String(), "\r\n", "\n"))
+		return err
+	}
+
+	return smtp.SendMail(n.Relay, nil, n.From, to, msg.Bytes())
+}
+
+// Lift the throttle on the held keys, mapped to their tokens
+func (n *EmailNotifier) releaseAll(held map[string]string) {
+	for key, token := range held {
+		if err := n.release(key, token); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+				"key": key,
+			}).Warn("Failed to release email throttle")
+		}
+	}
+}
+
+// Email owner. If key is set recipients already sent a notice with the same
+// key within the throttle period are skipped. The throttle is lifted again if
+// the email can't be sent. Returns the recipients emailed.
+func (n *EmailNotifier) deliver(owner *Owner, key, subject, body string) ([]string, error) {
+	if !n.DryRun && n.quiet(time.Now()) {
+		return nil, errQuietHours
+	}
+
+	uids, err := n.Recipients(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]string, 0, len(uids))
+	held := make(map[string]string, len(uids))
+	for _, uid := range uids {
+		addr := n.address(uid)
+		if !n.DryRun && n.Throttle > 0 && len(key) > 0 {
+			token, ok, err := n.allow(addr + ":" + key)
+			if err != nil {
+				n.releaseAll(held)
+				return nil, err
+			}
+			if !ok {
+				log.WithFields(log.Fields{
+					"recipient": addr,
+					"subject":   subject,
+				}).Info("Recipient sent this notice recently, skipping")
+				continue
+			}
+			held[addr+":"+key] = token
+		}
+
+		to = append(to, addr)
+	}
+
+	if len(to) == 0 {
+		return nil, errThrottled
+	}
+
+	sort.Strings(to)
+	err = n.send(to, subject, body)
+	if err != nil {
+		n.releaseAll(held)
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"owner":      owner.Name,
+		"recipients": strings.Join(to, ","),
+		"dry_run":    n.DryRun,
+	}).Warnf("Emailed %s", subject)
+
+	return to, nil
+}
+
+// Notify emails a notice to the owner. Callers only notify once per event so
+// these are not throttled.
+func (n *EmailNotifier) Notify(owner *Owner, subject, message string) error {
+	_, err := n.deliver(owner, "", subject, message)
+	return err
+}
+
+// Render and email a warning or recovery notice for q
+func (n *EmailNotifier) notice(t *emailTemplate, q *iquota.Quota, level string) ([]string, error) {
+	owner := PathOwner(q.Path)
+	msg := &noticeMessage{
+		Owner:   owner,
+		Quota:   q,
+		Level:   level,
+		Status:  levelStatus[level],
+		Percent: math.Max(q.PercentSoft(iquota.UsageBytes), q.PercentSoft(iquota.UsageInodes)),
+	}
+
+	subject, body, err := t.render(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Throttle per quota and level so owners of several quotas hear about
+	// each one and escalations and recoveries are never held back by an
+	// earlier notice
+	return n.deliver(owner, q.Path+":"+level, subject, body)
+}
+
+// Log a notice that was not sent. Throttled notices and those held during
+// quiet hours are expected and are retried on the next check.
+func logNoticeError(err error, path, msg string) {
+	if errors.Is(err, errThrottled) || errors.Is(err, errQuietHours) {
+		log.WithFields(log.Fields{
+			"path":   path,
+			"reason": err,
+		}).Debug("Skipped quota email")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"err":  err,
+		"path": path,
+	}).Error(msg)
+}
+
+// Check all cached quotas and email warnings and recovery notices. Quotas are
+// warned when they first reach the warning percent and again each time they
+// reach a worse level. Warnings or recoveries that can't be sent are retried
+// on the next check.
+func (n *EmailNotifier) check() {
+	if !n.DryRun && n.quiet(time.Now()) {
+		return
+	}
+
+	cache := Conf().Cache()
+	quotas, err := cache.SearchDirectoryQuotaCache("")
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch quotas for email notifications")
+		return
+	}
+
+	notices, err := cache.ListNotices()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch sent notices")
+		return
+	}
+
+	sent := make(map[string]*iquota.Notice, len(notices))
+	for _, notice := range notices {
+		sent[notice.Path] = notice
+	}
+
+	percent, clear := n.thresholds()
+	for _, q := range quotas {
+		// Only home and group directories have an owner to email
+		if PathOwner(q.Path) == nil {
+			continue
+		}
+
+		prev := sent[q.Path]
+		level := q.Level(percent)
+
+		if prev != nil && q.Level(clear) == iquota.LevelOK {
+			_, err := n.notice(n.recovery, q, iquota.LevelOK)
+			if err == nil && !n.DryRun {
+				err = cache.DeleteNotice(q.Path)
+			}
+			if err != nil {
+				logNoticeError(err, q.Path, "Failed to send quota recovery email")
+			}
+			continue
+		}
+
+		if level == iquota.LevelOK || (prev != nil && levelRank[level] <= levelRank[prev.Level]) {
+			continue
+		}
+
+		to, err := n.notice(n.warning, q, level)
+		if err == nil && !n.DryRun {
+			err = cache.SetNotice(&iquota.Notice{Path: q.Path, Level: level, Recipients: to, Sent: time.Now()})
+		}
+		if err != nil {
+			logNoticeError(err, q.Path, "Failed to send quota warning email")
+		}
+	}
+}
+
+// Run checks the cached quotas and emails owners every email.interval seconds
+// until done is closed
+func (n *EmailNotifier) Run(done <-chan struct{}) {
+	interval := time.Duration(viper.GetInt("email.interval")) * time.Second
+	if !n.Enabled() || interval <= 0 {
+		log.Info("Quota email notifications disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Only one server should email owners when running more than one.
+		// The lock expires just before the next run
+		_, ok, err := Conf().Cache().AcquireLock("email", interval*9/10)
+		if err == nil && ok {
+			n.check()
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func notifyCommand() cli.Command {
+	return cli.Command{
+		Name:  "notify",
+		Usage: "Email quota warnings and recovery notices to owners now",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "dry-run,n", Usage: "Print the emails instead of sending them"},
+		},
+		Action: func(c *cli.Context) error {
+			n, err := NewEmailNotifier(viper.GetViper())
+			if err != nil {
+				return err
+			}
+
+			n.DryRun = c.Bool("dry-run")
+			if !n.Enabled() && !n.DryRun {
+				return errors.New("Please set email.relay to send email")
+			}
+
+			n.check()
+			return nil
+		},
+	}
+}
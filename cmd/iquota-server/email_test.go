@@ -0,0 +1,286 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+// Message received by the SMTP stand-in
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Starts a minimal SMTP server on localhost that accepts every message and
+// sends it on the returned channel
+func smtpStandIn(t *testing.T) (string, <-chan *smtpMessage) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan *smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+				msg := &smtpMessage{}
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimRight(line, "\r\n")
+					cmd := strings.ToUpper(line)
+
+					switch {
+					case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+						reply("250 localhost")
+					case strings.HasPrefix(cmd, "MAIL FROM:"):
+						msg.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+						reply("250 OK")
+					case strings.HasPrefix(cmd, "RCPT TO:"):
+						msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+						reply("250 OK")
+					case cmd == "DATA":
+						reply("354 End data with <CR><LF>.<CR><LF>")
+						var data strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							data.WriteString(l)
+						}
+						msg.Data = data.String()
+						messages <- msg
+						msg = &smtpMessage{}
+						reply("250 OK")
+					case cmd == "QUIT":
+						reply("221 Bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String(), messages
+}
+
+func newTestEmailNotifier(t *testing.T, settings map[string]interface{}) *EmailNotifier {
+	config.Store(&Config{
+		HomeDir:       "/home",
+		GroupDirs:     []string{"/projects"},
+		OverPercent:   90,
+		GroupManagers: map[string][]string{"grp-managed": {"alice"}},
+	})
+
+	v := viper.New()
+	setDefaults(v)
+	v.Set("email.from", "iquota@example.com")
+	v.Set("email.domain", "example.com")
+	for k, val := range settings {
+		v.Set(k, val)
+	}
+
+	n, err := NewEmailNotifier(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.members = func(group string) ([]string, error) {
+		return []string{"carol", "bob"}, nil
+	}
+
+	sent := make(map[string]bool)
+	n.allow = func(key string) (string, bool, error) {
+		if sent[key] {
+			return "", false, nil
+		}
+		sent[key] = true
+		return key, true, nil
+	}
+	n.release = func(key, token string) error {
+		delete(sent, key)
+		return nil
+	}
+
+	return n
+}
+
+func TestEmailRecipients(t *testing.T) {
+	n := newTestEmailNotifier(t, nil)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home/dave", "dave"},
+		{"/projects/grp-managed", "alice"},
+		{"/projects/grp-other", "carol,bob"},
+	}
+
+	for _, test := range tests {
+		uids, err := n.Recipients(PathOwner(test.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(uids, ","); got != test.want {
+			t.Errorf("Recipients(%s) = %s, want %s", test.path, got, test.want)
+		}
+	}
+
+	n.GroupRecipients = "members"
+	uids, _ := n.Recipients(PathOwner("/projects/grp-managed"))
+	if got := strings.Join(uids, ","); got != "carol,bob" {
+		t.Errorf("Recipients with members = %s, want carol,bob", got)
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+	addr, messages := smtpStandIn(t)
+	n := newTestEmailNotifier(t, map[string]interface{}{"email.relay": addr})
+
+	q := &iquota.Quota{Path: "/projects/grp-other", Used: 95 * 1000 * 1000, SoftLimit: 100 * 1000 * 1000}
+	to, err := n.notice(n.warning, q, q.Level(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(to, ","); got != "bob@example.com,carol@example.com" {
+		t.Errorf("Wrong recipients: %s", got)
+	}
+
+	select {
+	case msg := <-messages:
+		if msg.From != "iquota@example.com" {
+			t.Errorf("Wrong sender: %s", msg.From)
+		}
+		if len(msg.To) != 2 {
+			t.Errorf("Wrong number of recipients: %v", msg.To)
+		}
+		for _, want := range []string{"Subject: Quota warning for /projects/grp-other", "nearing its soft limit", "95 MB of 100 MB (95%"} {
+			if !strings.Contains(msg.Data, want) {
+				t.Errorf("Message missing %q:\n%s", want, msg.Data)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message received")
+	}
+
+	// Both recipients were just sent this warning
+	_, err = n.notice(n.warning, q, q.Level(90))
+	if err != errThrottled {
+		t.Errorf("Expected throttled error got: %v", err)
+	}
+
+	// Other levels and quotas are throttled separately
+	over := &iquota.Quota{Path: q.Path, Used: 105 * 1000 * 1000, SoftLimit: 100 * 1000 * 1000}
+	other := &iquota.Quota{Path: "/projects/grp-managed", Used: 95 * 1000 * 1000, SoftLimit: 100 * 1000 * 1000}
+	for _, notice := range []struct {
+		t     *emailTemplate
+		q     *iquota.Quota
+		level string
+	}{
+		{n.warning, over, over.Level(90)},
+		{n.recovery, q, iquota.LevelOK},
+		{n.warning, other, other.Level(90)},
+	} {
+		if _, err := n.notice(notice.t, notice.q, notice.level); err != nil {
+			t.Errorf("Notice for %s at %s not sent: %s", notice.q.Path, notice.level, err)
+			continue
+		}
+		<-messages
+	}
+}
+
+func TestEmailSendFailure(t *testing.T) {
+	// Nothing listens on the relay port so sending fails
+	n := newTestEmailNotifier(t, map[string]interface{}{"email.relay": "127.0.0.1:1"})
+
+	q := &iquota.Quota{Path: "/home/dave", Used: 95 * 1000 * 1000, SoftLimit: 100 * 1000 * 1000}
+	for i := 0; i < 2; i++ {
+		_, err := n.notice(n.warning, q, q.Level(90))
+		if err == nil || err == errThrottled {
+			t.Fatalf("Expected send error got: %v", err)
+		}
+	}
+}
+
+func TestEmailDryRun(t *testing.T) {
+	n := newTestEmailNotifier(t, map[string]interface{}{
+		"email.templates.recovery.subject": "All good {{.Owner.Name}}",
+	})
+
+	var out bytes.Buffer
+	n.DryRun = true
+	n.Out = &out
+
+	q := &iquota.Quota{Path: "/home/dave", Used: 10, SoftLimit: 100}
+	_, err := n.notice(n.recovery, q, iquota.LevelOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"To: dave@example.com", "Subject: All good dave", "back under its limits"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Dry run output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestEmailQuietHours(t *testing.T) {
+	n := newTestEmailNotifier(t, map[string]interface{}{"email.quiet_hours": "22:00-07:30"})
+
+	tests := []struct {
+		clock string
+		quiet bool
+	}{
+		{"21:59", false},
+		{"22:00", true},
+		{"03:00", true},
+		{"07:29", true},
+		{"07:30", false},
+		{"12:00", false},
+	}
+
+	for _, test := range tests {
+		tm, _ := time.Parse("15:04", test.clock)
+		if got := n.quiet(tm); got != test.quiet {
+			t.Errorf("quiet(%s) = %v, want %v", test.clock, got, test.quiet)
+		}
+	}
+
+	_, _, err := parseQuietHours("25:00-07:00")
+	if err == nil {
+		t.Error("Expected error for invalid quiet hours")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	group "os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+const (
+	// Number of events buffered per subscriber before events are dropped
+	eventBuffer = 256
+
+	// Interval between keep alive comments sent on idle streams
+	keepAliveInterval = 15 * time.Second
+)
+
+// EventBroker watches the quota cache for changes and fans out quota events
+// to subscribed streams
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan *iquota.QuotaEvent]struct{}
+	snapshot    map[string]*iquota.Quota
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: make(map[chan *iquota.QuotaEvent]struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Close ends all open streams. Called on shutdown since the server waits for
+// in-flight requests to finish
+func (b *EventBroker) Close() {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+}
+
+// Subscribe returns a channel of quota events and a function to unsubscribe
+func (b *EventBroker) Subscribe() (<-chan *iquota.QuotaEvent, func()) {
+	ch := make(chan *iquota.QuotaEvent, eventBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *EventBroker) hasSubscribers() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subscribers) > 0
+}
+
+// Send event to all subscribers. Events are dropped for subscribers that are
+// not keeping up rather than blocking everyone else
+func (b *EventBroker) publish(event *iquota.QuotaEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.WithFields(log.Fields{
+				"path": event.Quota.Path,
+				"type": event.Type,
+			}).Warn("Event stream subscriber too slow, dropping event")
+		}
+	}
+}
+
+// Compare the cached quotas with the last snapshot and publish any changes.
+// The first check only records the snapshot.
+func (b *EventBroker) check() {
+	if !b.hasSubscribers() {
+		b.snapshot = nil
+		return
+	}
+
+	conf := Conf()
+	quotas, err := conf.Cache().SearchDirectoryQuotaCache("")
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch quotas for event stream")
+		return
+	}
+
+	now := time.Now()
+	snapshot := make(map[string]*iquota.Quota, len(quotas))
+	for _, q := range quotas {
+		snapshot[q.Path] = q
+
+		if b.snapshot == nil {
+			continue
+		}
+
+		for _, event := range iquota.DiffQuota(b.snapshot[q.Path], q, conf.OverPercent, now) {
+			b.publish(event)
+		}
+	}
+
+	b.snapshot = snapshot
+}
+
+// Run checks the quota cache for changes every events.interval seconds until
+// done is closed
+func (b *EventBroker) Run(done <-chan struct{}) {
+	interval := time.Duration(viper.GetInt("events.interval")) * time.Second
+	if interval <= 0 {
+		log.Warn("Quota event stream disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		b.check()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns a filter for the events visible to user using the same query params
+// and authorization rules as Quota
+func eventFilter(c echo.Context, user *User) (func(*iquota.Quota) bool, error) {
+	if path := c.QueryParam("path"); len(path) > 0 {
+		path = filepath.Clean(path)
+		return func(q *iquota.Quota) bool {
+			return q.Path == path
+		}, nil
+	}
+
+	if userFilter := c.QueryParam("user"); len(userFilter) > 0 {
+		if userFilter != user.UID && !user.IsAdmin() {
+			return nil, echo.ErrUnauthorized
+		}
+
+		home := filepath.Join(Conf().HomeDir, userFilter)
+		return func(q *iquota.Quota) bool {
+			return q.Path == home
+		}, nil
+	}
+
+	if groupFilter := c.QueryParam("group"); len(groupFilter) > 0 {
+		_, err := group.LookupGroup(groupFilter)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, nil)
+		}
+
+		if !user.HasGroup(groupFilter) && !user.IsAdmin() {
+			return nil, echo.ErrUnauthorized
+		}
+
+		return func(q *iquota.Quota) bool {
+			owner := PathOwner(q.Path)
+			return owner != nil && owner.Group && owner.Name == groupFilter
+		}, nil
+	}
+
+	return func(q *iquota.Quota) bool {
+		return user.CanView(q.Path)
+	}, nil
+}
+
+// Stream quota changes visible to the user as Server-Sent Events
+func (h *Handler) Events(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if viper.GetInt("events.interval") <= 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Event stream disabled")
+	}
+
+	visible, err := eventFilter(c, user)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("User %s following quota events", user.UID)
+
+	// Streams outlive the server write timeout
+	rc := http.NewResponseController(c.Response())
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Streaming not supported")
+	}
+
+	events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", viper.GetInt("events.interval")*1000)
+	res.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-h.events.closed:
+			return nil
+		case <-keepAlive.C:
+			fmt.Fprint(res, ": keep-alive\n\n")
+		case event := <-events:
+			if !visible(event.Quota) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+
+		res.Flush()
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/http"
 	group "os/user"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
@@ -17,16 +18,84 @@ import (
 )
 
 type Handler struct {
-	cache *iquota.Cache
+	alerts     *AlertEngine
+	audit      *AuditLogger
+	backends   *iquota.Backends
+	collectors *CollectorScheduler
+	email      *EmailNotifier
+	events     *EventBroker
+	live       *LiveQuotas
+	notifier   Notifier
 }
 
 func NewHandler() (*Handler, error) {
-	return &Handler{cache: &iquota.Cache{}}, nil
+	audit, err := NewAuditLogger()
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := iquota.NewRoutes(viper.GetViper(), "routes")
+	if err != nil {
+		return nil, err
+	}
+
+	backends, err := iquota.NewBackends(viper.GetViper(), "backends", routes)
+	if err != nil {
+		return nil, err
+	}
+
+	collectors, err := NewCollectorScheduler(viper.GetViper(), backends)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts, err := NewAlertEngine(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := NewEmailNotifier(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{alerts: alerts, audit: audit, backends: backends, collectors: collectors, email: email, events: NewEventBroker(), live: NewLiveQuotas(backends), notifier: &logNotifier{}}
+	if email.Enabled() {
+		h.notifier = email
+	}
+
+	return h, nil
 }
 
 func (h *Handler) SetupRoutes(e *echo.Echo) {
-	e.GET("/quota", KerbAuthRequired(h.Quota)).Name = "quota"
-	e.GET("/export", KerbAuthRequired(h.Export)).Name = "export"
+	auth := []echo.MiddlewareFunc{IPRateLimit(), KerbAuthRequired, h.audit.Middleware(), UserRateLimit()}
+
+	e.GET("/quota", h.Quota, auth...).Name = "quota"
+	e.PUT("/quota", h.SetQuota, auth...).Name = "quota-set"
+	e.DELETE("/quota", h.DeleteQuota, auth...).Name = "quota-delete"
+	e.GET("/export", h.Export, auth...).Name = "export"
+	e.GET("/over", h.Over, auth...).Name = "over"
+	e.GET("/requests", h.ListRequests, auth...).Name = "requests"
+	e.POST("/requests", h.SubmitRequest, auth...).Name = "request-submit"
+	e.GET("/requests/:id", h.GetRequest, auth...).Name = "request"
+	e.POST("/requests/:id/approve", h.ApproveRequest, auth...).Name = "request-approve"
+	e.POST("/requests/:id/deny", h.DenyRequest, auth...).Name = "request-deny"
+	e.GET("/temp", h.ListTempIncreases, auth...).Name = "temp"
+	e.POST("/temp", h.SetTempIncrease, auth...).Name = "temp-set"
+	e.DELETE("/temp", h.RevertTempIncrease, auth...).Name = "temp-revert"
+	e.GET("/ondemand", h.OnDemand, auth...).Name = "ondemand"
+	e.GET("/me", h.Me, auth...).Name = "me"
+	e.GET("/history", h.History, auth...).Name = "history"
+	e.GET("/events", h.Events, auth...).Name = "events"
+	e.GET("/collectors", h.Collectors, auth...).Name = "collectors"
+	e.POST("/collectors/:name/refresh", h.RefreshCollector, auth...).Name = "collector-refresh"
+	e.GET("/ui/*", uiHandler(), auth...).Name = "ui"
+	e.GET("/", func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, "/ui/")
+	})
+	e.GET("/healthz", h.Healthz).Name = "healthz"
+	e.GET("/readyz", h.Readyz).Name = "readyz"
+	e.GET("/openapi.yaml", OpenAPI).Name = "openapi"
 }
 
 func (h *Handler) Quota(c echo.Context) error {
@@ -37,9 +106,30 @@ func (h *Handler) Quota(c echo.Context) error {
 	user := u.(*User)
 	log.Infof("User %s requesting quota", user.UID)
 
+	conf := Conf()
+	cache := conf.Cache()
+
 	path := c.QueryParam("path")
+	if len(path) > 0 && c.QueryParam("parents") == "true" {
+		quotas, err := h.governingQuotas(path)
+		if err != nil {
+			if errors.Is(err, iquota.ErrNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, nil)
+			}
+
+			log.WithFields(log.Fields{
+				"err":  err,
+				"path": path,
+			}).Error("Failed to fetch quotas governing path")
+
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get quota")
+		}
+
+		return c.JSON(http.StatusOK, quotas)
+	}
+
 	if len(path) > 0 {
-		quota, err := h.cache.GetDirectoryQuotaCache(path)
+		quota, err := h.lookupQuota(path)
 		if err != nil {
 			if errors.Is(err, iquota.ErrNotFound) {
 				return echo.NewHTTPError(http.StatusNotFound, nil)
@@ -62,7 +152,7 @@ func (h *Handler) Quota(c echo.Context) error {
 			return echo.ErrUnauthorized
 		}
 
-		quota, err := h.cache.GetDirectoryQuotaCache(fmt.Sprintf("%s/%s", viper.GetString("home_dir"), userFilter))
+		quota, err := h.lookupQuota(fmt.Sprintf("%s/%s", conf.HomeDir, userFilter))
 		if err != nil {
 			if errors.Is(err, iquota.ErrNotFound) {
 				return echo.NewHTTPError(http.StatusNotFound, nil)
@@ -90,7 +180,7 @@ func (h *Handler) Quota(c echo.Context) error {
 			return echo.ErrUnauthorized
 		}
 
-		quotas, err := h.cache.SearchDirectoryQuotaCache(groupFilter)
+		quotas, err := cache.SearchDirectoryQuotaCache(groupFilter)
 		if err != nil {
 			if errors.Is(err, iquota.ErrNotFound) {
 				return echo.NewHTTPError(http.StatusNotFound, nil)
@@ -108,7 +198,7 @@ func (h *Handler) Quota(c echo.Context) error {
 	}
 
 	// Default to returning quota for user
-	quota, err := h.cache.GetDirectoryQuotaCache(fmt.Sprintf("%s/%s", viper.GetString("home_dir"), user.UID))
+	quota, err := h.lookupQuota(fmt.Sprintf("%s/%s", conf.HomeDir, user.UID))
 	if err != nil {
 		if errors.Is(err, iquota.ErrNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, nil)
@@ -133,11 +223,14 @@ func (h *Handler) Export(c echo.Context) error {
 	user := u.(*User)
 	log.Infof("User %s requesting export", user.UID)
 
+	conf := Conf()
+	cache := conf.Cache()
+
 	if !user.IsAdmin() {
 		return echo.ErrUnauthorized
 	}
 
-	quotas, err := h.cache.SearchDirectoryQuotaCache("")
+	quotas, err := cache.SearchDirectoryQuotaCache("")
 	if err != nil {
 		if errors.Is(err, iquota.ErrNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, nil)
@@ -152,3 +245,53 @@ func (h *Handler) Export(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, quotas)
 }
+
+func (h *Handler) Over(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+	log.Infof("User %s requesting over quota report", user.UID)
+
+	conf := Conf()
+	cache := conf.Cache()
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	pct := conf.OverPercent
+	if p := c.QueryParam("percent"); len(p) > 0 {
+		var err error
+		pct, err = strconv.ParseFloat(p, 64)
+		if err != nil || pct < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid percent")
+		}
+	}
+
+	limit := c.QueryParam("limit")
+	if len(limit) > 0 && limit != iquota.LimitSoft && limit != iquota.LimitHard {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid limit. Must be one of soft or hard")
+	}
+
+	usageType := c.QueryParam("type")
+	if len(usageType) > 0 && usageType != iquota.UsageBytes && usageType != iquota.UsageInodes {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid type. Must be one of bytes or inodes")
+	}
+
+	quotas, err := cache.SearchDirectoryQuotaCache("")
+	if err != nil {
+		if errors.Is(err, iquota.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, nil)
+		}
+
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch quotas for over quota report")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch over quota report")
+	}
+
+	return c.JSON(http.StatusOK, iquota.FindOverQuota(quotas, pct, limit, usageType))
+}
@@ -0,0 +1,211 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+const (
+	StatusOK    = "ok"
+	StatusFail  = "fail"
+	StatusStale = "stale"
+)
+
+var (
+	// Kerberos keytab file format versions. See keytab.txt in the MIT
+	// Kerberos sources
+	keytabMagic = [][]byte{{0x05, 0x01}, {0x05, 0x02}}
+)
+
+// Result of a single dependency check
+type Check struct {
+	Status  string  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+	Elapsed float64 `json:"elapsed_seconds"`
+}
+
+// Freshness of a quota collector
+type CollectorHealth struct {
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	Age         float64   `json:"age_seconds"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Health status report returned by /healthz and /readyz
+type Health struct {
+	Status     string             `json:"status"`
+	Time       time.Time          `json:"time"`
+	Checks     map[string]*Check  `json:"checks"`
+	Collectors []*CollectorHealth `json:"collectors"`
+}
+
+func checkRedis() error {
+	return Conf().Cache().Ping()
+}
+
+func checkGroups() error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return err
+	}
+
+	obj := conn.Object("org.freedesktop.sssd.infopipe", dbus.ObjectPath("/org/freedesktop/sssd/infopipe"))
+	return obj.Call("org.freedesktop.DBus.Peer.Ping", 0).Err
+}
+
+func checkKeytab() error {
+	keytab := viper.GetString("keytab")
+	if len(keytab) == 0 {
+		return errors.New("No keytab configured")
+	}
+
+	f, err := os.Open(keytab)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 2)
+	_, err = io.ReadFull(f, magic)
+	if err != nil {
+		return fmt.Errorf("Failed to read keytab: %s", err)
+	}
+
+	for _, m := range keytabMagic {
+		if bytes.Equal(m, magic) {
+			return nil
+		}
+	}
+
+	return errors.New("Invalid keytab file format")
+}
+
+// Run check with a timeout
+func runCheck(check func() error, timeout time.Duration) *Check {
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- check()
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-time.After(timeout):
+		err = fmt.Errorf("Timed out after %s", timeout)
+	}
+
+	res := &Check{Status: StatusOK, Elapsed: time.Since(start).Seconds()}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// CheckHealth checks all dependencies of the server and the freshness of each
+// quota collector
+func CheckHealth() *Health {
+	timeout := time.Duration(viper.GetInt("health_timeout")) * time.Second
+	checks := map[string]func() error{
+		"redis":  checkRedis,
+		"groups": checkGroups,
+		"keytab": checkKeytab,
+	}
+
+	health := &Health{
+		Status:     StatusOK,
+		Time:       time.Now(),
+		Checks:     make(map[string]*Check, len(checks)),
+		Collectors: make([]*CollectorHealth, 0),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			res := runCheck(check, timeout)
+			mu.Lock()
+			health.Checks[name] = res
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, res := range health.Checks {
+		if res.Status != StatusOK {
+			health.Status = StatusFail
+		}
+	}
+
+	// Collector status is stored in redis so no point checking if it's down
+	if health.Checks["redis"].Status != StatusOK {
+		return health
+	}
+
+	statuses, err := Conf().Cache().ListCollectorStatus()
+	if err != nil {
+		health.Status = StatusFail
+		health.Checks["redis"].Status = StatusFail
+		health.Checks["redis"].Error = err.Error()
+		return health
+	}
+
+	maxAge := viper.GetFloat64("collector_max_age")
+	for _, s := range statuses {
+		ch := &CollectorHealth{
+			Name:        s.Name,
+			Status:      StatusOK,
+			LastRun:     s.LastRun,
+			LastSuccess: s.LastSuccess,
+			Age:         health.Time.Sub(s.LastSuccess).Seconds(),
+			Error:       s.Error,
+		}
+
+		if ch.Age > maxAge {
+			ch.Status = StatusStale
+			health.Status = StatusFail
+		}
+
+		health.Collectors = append(health.Collectors, ch)
+	}
+
+	return health
+}
+
+// Liveness check. Always returns 200 if the server is up along with the status
+// of each dependency
+func (h *Handler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, CheckHealth())
+}
+
+// Readiness check. Returns 503 if any dependency is failing or a collector
+// has not run successfully within collector_max_age
+func (h *Handler) Readyz(c echo.Context) error {
+	health := CheckHealth()
+	if health.Status != StatusOK {
+		return c.JSON(http.StatusServiceUnavailable, health)
+	}
+
+	return c.JSON(http.StatusOK, health)
+}
@@ -34,6 +34,7 @@ install -d %{buildroot}%{_datadir}/%{name}
 install -d %{buildroot}%{_sysconfdir}/iquota
 install -d %{buildroot}%{_bindir}
 install -d %{buildroot}%{_usr}/lib/systemd/system
+install -d %{buildroot}%{_localstatedir}/log/iquota
 
 cp -a ./iquota.yaml.sample %{buildroot}%{_sysconfdir}/iquota/iquota.yaml
 cp -a ./%{name} %{buildroot}%{_bindir}/%{name}
@@ -44,18 +45,33 @@ cat << EOF > %{buildroot}%{_usr}/lib/systemd/system/%{name}.service
 Description=iquota server
 After=syslog.target
 After=network.target
+After=%{name}.socket
 
 [Service]
-Type=simple
+Type=notify
 User=iquota
 Group=iquota
 WorkingDirectory=%{_sysconfdir}/iquota
-ExecStart=/bin/bash -c '%{_bindir}/%{name}'
-Restart=on-abort
+ExecStart=%{_bindir}/%{name}
+ExecReload=/bin/kill -HUP \$MAINPID
+Restart=on-failure
+TimeoutStopSec=60
+WatchdogSec=30
 
 [Install]
 WantedBy=multi-user.target
 EOF
+cat << EOF > %{buildroot}%{_usr}/lib/systemd/system/%{name}.socket
+[Unit]
+Description=iquota server socket
+
+[Socket]
+# Must match the bind/port settings in iquota.yaml
+ListenStream=8080
+
+[Install]
+WantedBy=sockets.target
+EOF
 
 %clean
 rm -rf %{buildroot}
@@ -69,6 +85,8 @@ rm -rf %{buildroot}
 %attr(0755,root,root) %{_bindir}/ivast
 %attr(640,root,iquota) %config(noreplace) %{_sysconfdir}/iquota/iquota.yaml
 %attr(644,root,root) %{_usr}/lib/systemd/system/%{name}.service
+%attr(644,root,root) %{_usr}/lib/systemd/system/%{name}.socket
+%dir %attr(750,iquota,iquota) %{_localstatedir}/log/iquota
 
 %changelog
 * Sun Jan 31 2021  Andrew E. Bruno <aebruno2@buffalo.edu> 0.0.6-1
@@ -0,0 +1,285 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+)
+
+var (
+	errBreakerOpen = errors.New("Storage backend unavailable")
+)
+
+// Circuit breaker for live queries to a storage backend. Opens after
+// breaker_failures consecutive failures and then lets a single query through
+// every breaker_cooldown seconds to check if the backend has recovered.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Returns true if a query may be sent to the backend at time now
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+// Record the result of a query. Returns true if the breaker opened
+func (b *breaker) record(err error, now time.Time, failures int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return false
+	}
+
+	b.failures++
+	if b.failures < failures {
+		return false
+	}
+
+	b.openUntil = now.Add(cooldown)
+	return true
+}
+
+type liveCall struct {
+	done  chan struct{}
+	quota *iquota.Quota
+	err   error
+}
+
+// LiveQuotas queries quotas directly from the storage backends. Concurrent
+// lookups of the same path share a single backend query and each backend has
+// its own circuit breaker.
+type LiveQuotas struct {
+	backends *iquota.Backends
+
+	mu       sync.Mutex
+	calls    map[string]*liveCall
+	breakers map[string]*breaker
+}
+
+func NewLiveQuotas(backends *iquota.Backends) *LiveQuotas {
+	return &LiveQuotas{
+		backends: backends,
+		calls:    make(map[string]*liveCall),
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (l *LiveQuotas) breaker(name string) *breaker {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.breakers[name]
+	if !ok {
+		b = &breaker{}
+		l.breakers[name] = b
+	}
+
+	return b
+}
+
+// Get fetches the quota on path from the backend that owns it and writes it
+// back to the cache. Returns iquota.ErrNoBackend if no backend owns path.
+func (l *LiveQuotas) Get(path string) (*iquota.Quota, error) {
+	l.mu.Lock()
+	if call, ok := l.calls[path]; ok {
+		l.mu.Unlock()
+		<-call.done
+		return call.quota, call.err
+	}
+
+	call := &liveCall{done: make(chan struct{})}
+	l.calls[path] = call
+	l.mu.Unlock()
+
+	call.quota, call.err = l.fetch(path)
+	close(call.done)
+
+	l.mu.Lock()
+	delete(l.calls, path)
+	l.mu.Unlock()
+
+	return call.quota, call.err
+}
+
+func (l *LiveQuotas) fetch(path string) (*iquota.Quota, error) {
+	backend, err := l.backends.ForPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := Conf()
+	b := l.breaker(backend.Name())
+	if !b.allow(time.Now()) {
+		return nil, errBreakerOpen
+	}
+
+	// Backends don't take a context so give up waiting after the timeout. The
+	// query is left to finish in the background
+	timeout := time.Duration(conf.BackendTimeout) * time.Second
+	type result struct {
+		quota *iquota.Quota
+		err   error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		quota, err := backend.GetQuota(path)
+		resc <- result{quota, err}
+	}()
+
+	var res result
+	select {
+	case res = <-resc:
+	case <-time.After(timeout):
+		res.err = fmt.Errorf("Timed out after %s", timeout)
+	}
+
+	// Paths without a quota are a valid answer from the backend
+	var failure error
+	if res.err != nil && !errors.Is(res.err, iquota.ErrNotFound) {
+		failure = res.err
+	}
+
+	if b.record(failure, time.Now(), conf.BreakerFailures, time.Duration(conf.BreakerCooldown)*time.Second) {
+		log.WithFields(log.Fields{
+			"backend":  backend.Name(),
+			"cooldown": conf.BreakerCooldown,
+		}).Error("Storage backend failing, pausing live quota lookups")
+	}
+
+	cache := conf.Cache()
+	if errors.Is(res.err, iquota.ErrNotFound) {
+		if conf.NegCacheExpire > 0 {
+			cache.SetNegativeCache(path, conf.NegCacheExpire)
+		}
+		return nil, iquota.ErrNotFound
+	}
+
+	if res.err != nil {
+		log.WithFields(log.Fields{
+			"err":     res.err,
+			"path":    path,
+			"backend": backend.Name(),
+		}).Error("Failed to fetch live quota")
+		return nil, res.err
+	}
+
+	err = cache.SetDirectoryQuotaCache(path, res.quota)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Warn("Failed to cache live quota")
+	}
+
+	return res.quota, nil
+}
+
+// Returns the quota on path. If read_through is enabled a cache miss, or
+// every lookup if enable_cache is off, queries the storage backend that owns
+// path. With the cache off the cached quota is still used for paths without a
+// backend or when the backend is unavailable.
+func (h *Handler) lookupQuota(path string) (*iquota.Quota, error) {
+	conf := Conf()
+	cache := conf.Cache()
+	path = filepath.Clean(path)
+
+	if !conf.ReadThrough {
+		return cache.GetDirectoryQuotaCache(path)
+	}
+
+	if conf.EnableCache {
+		quota, err := cache.GetDirectoryQuotaCache(path)
+		if !errors.Is(err, iquota.ErrNotFound) {
+			return quota, err
+		}
+
+		if cache.IsNegativeCached(path) {
+			return nil, iquota.ErrNotFound
+		}
+	}
+
+	quota, err := h.live.Get(path)
+	if err == nil || errors.Is(err, iquota.ErrNotFound) {
+		return quota, err
+	}
+
+	if !conf.EnableCache {
+		return cache.GetDirectoryQuotaCache(path)
+	}
+
+	if errors.Is(err, iquota.ErrNoBackend) {
+		return nil, iquota.ErrNotFound
+	}
+
+	return nil, err
+}
+
+// Max number of directories, path and its parents, searched for the quotas
+// governing a path
+const MaxGoverningDepth = 16
+
+// Returns the quotas on path and its parent directories, innermost first.
+// These are all the quotas that limit writes to path. The search stops at the
+// prefix of the route that owns path, since quotas above it belong to another
+// storage system, and after MaxGoverningDepth directories. Returns
+// iquota.ErrNotFound if none of them has a quota.
+func (h *Handler) governingQuotas(path string) ([]*iquota.Quota, error) {
+	path = filepath.Clean(path)
+	if !filepath.IsAbs(path) {
+		return nil, iquota.ErrNotFound
+	}
+
+	top := "/"
+	if route, ok := h.backends.Routes().Match(path); ok {
+		top = route.Prefix
+	}
+
+	quotas := make([]*iquota.Quota, 0)
+	for depth := 0; depth < MaxGoverningDepth; depth++ {
+		quota, err := h.lookupQuota(path)
+		if err == nil {
+			quotas = append(quotas, quota)
+		} else if !errors.Is(err, iquota.ErrNotFound) {
+			return nil, err
+		}
+
+		if path == top || path == "/" {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	if len(quotas) == 0 {
+		return nil, iquota.ErrNotFound
+	}
+
+	return quotas, nil
+}
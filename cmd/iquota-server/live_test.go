@@ -0,0 +1,281 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+// Backend that counts queries and blocks until released
+type testBackend struct {
+	calls    int32
+	returned int32
+	release  chan struct{}
+	err      error
+	lastPath atomic.Value
+
+	// Paths with a quota. All paths have one if nil
+	quotas map[string]bool
+
+	// Soft inode limit of every quota
+	inodes int
+}
+
+func (b *testBackend) Name() string { return "test" }
+
+func (b *testBackend) GetQuota(path string) (*iquota.Quota, error) {
+	atomic.AddInt32(&b.calls, 1)
+	defer atomic.AddInt32(&b.returned, 1)
+
+	b.lastPath.Store(path)
+	<-b.release
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.quotas != nil && !b.quotas[path] {
+		return nil, iquota.ErrNotFound
+	}
+	return &iquota.Quota{Path: path, SoftLimit: 100, SoftLimitInodes: b.inodes}, nil
+}
+
+// Wait until n queries have returned
+func (b *testBackend) wait(n int32) {
+	for atomic.LoadInt32(&b.returned) < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (b *testBackend) SetQuota(path string, limits *iquota.Limits) error { return nil }
+func (b *testBackend) DeleteQuota(path string) error                     { return nil }
+
+// Pads zero hard limits like the vast backend
+func (b *testBackend) ResolveLimits(limits *iquota.Limits) *iquota.Limits {
+	l := *limits
+	if l.HardLimit == 0 {
+		l.HardLimit = l.SoftLimit + 10
+	}
+	if l.HardLimitInodes == 0 {
+		l.HardLimitInodes = l.SoftLimitInodes + 10
+	}
+	return &l
+}
+
+// Backends for each test keyed by the id set in the backend config
+var testBackends sync.Map
+
+func init() {
+	iquota.RegisterDriver("test", func(name string, conf *viper.Viper) (iquota.Backend, error) {
+		b, ok := testBackends.Load(conf.GetString("id"))
+		if !ok {
+			return nil, fmt.Errorf("No test backend %s", conf.GetString("id"))
+		}
+		return b.(*testBackend), nil
+	})
+}
+
+func newTestLiveQuotas(t *testing.T, routes ...*iquota.Route) (*LiveQuotas, *testBackend) {
+	// Nothing listens on the redis port so writes back to the cache fail
+	config.Store(&Config{
+		HomeDir:         "/home",
+		Redis:           "127.0.0.1:1",
+		BackendTimeout:  1,
+		BreakerFailures: 2,
+		BreakerCooldown: 60,
+	})
+
+	backend := &testBackend{release: make(chan struct{})}
+	testBackends.Store(t.Name(), backend)
+	t.Cleanup(func() { testBackends.Delete(t.Name()) })
+
+	v := viper.New()
+	v.Set("backends.test.driver", "test")
+	v.Set("backends.test.id", t.Name())
+	v.Set("backends.test.paths", []string{"/test"})
+	backends, err := iquota.NewBackends(v, "backends", routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewLiveQuotas(backends), backend
+}
+
+func TestLiveCoalesce(t *testing.T) {
+	live, backend := newTestLiveQuotas(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			quota, err := live.Get("/test/grp-a")
+			if err != nil || quota.Path != "/test/grp-a" {
+				t.Errorf("Unexpected result: %v %v", quota, err)
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&backend.calls); n != 1 {
+		t.Errorf("Expected 1 backend query got %d", n)
+	}
+
+	_, err := live.Get("/other/grp-a")
+	if !errors.Is(err, iquota.ErrNoBackend) {
+		t.Errorf("Expected no backend error got %v", err)
+	}
+}
+
+func TestLiveBreaker(t *testing.T) {
+	live, backend := newTestLiveQuotas(t)
+	backend.err = errors.New("backend down")
+	close(backend.release)
+
+	for i := 0; i < 2; i++ {
+		_, err := live.Get("/test/grp-a")
+		if err != backend.err {
+			t.Fatalf("Expected backend error got %v", err)
+		}
+	}
+
+	_, err := live.Get("/test/grp-a")
+	if err != errBreakerOpen {
+		t.Fatalf("Expected breaker open got %v", err)
+	}
+
+	if n := atomic.LoadInt32(&backend.calls); n != 2 {
+		t.Errorf("Expected 2 backend queries got %d", n)
+	}
+
+	// After the cooldown a single probe is let through
+	b := live.breaker("test")
+	later := time.Now().Add(2 * time.Minute)
+	if !b.allow(later) || b.allow(later) {
+		t.Error("Expected exactly one probe after cooldown")
+	}
+
+	b.record(nil, later, 2, time.Minute)
+	if !b.allow(later) {
+		t.Error("Expected breaker to close after a successful probe")
+	}
+}
+
+func TestLiveTimeout(t *testing.T) {
+	live, backend := newTestLiveQuotas(t)
+
+	start := time.Now()
+	_, err := live.Get("/test/grp-a")
+	elapsed := time.Since(start)
+
+	// Let the abandoned query finish so it doesn't outlive the test
+	close(backend.release)
+	backend.wait(1)
+
+	if err == nil {
+		t.Fatal("Expected timeout error")
+	}
+
+	if elapsed > 2*time.Second {
+		t.Errorf("Lookup not cut off at the timeout: %s", elapsed)
+	}
+}
+
+func TestLiveRoutes(t *testing.T) {
+	live, backend := newTestLiveQuotas(t,
+		&iquota.Route{Prefix: "/mnt/test", Backend: "test", StoragePath: "/ifs/test"},
+		&iquota.Route{Prefix: "/mnt/test/other", Backend: "panfs", StoragePath: "/other"},
+	)
+	close(backend.release)
+
+	quota, err := live.Get("/mnt/test/grp-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := backend.lastPath.Load(); got != "/ifs/test/grp-a" {
+		t.Errorf("Backend queried for %v want /ifs/test/grp-a", got)
+	}
+	if quota.Path != "/mnt/test/grp-a" {
+		t.Errorf("Quota path %s want /mnt/test/grp-a", quota.Path)
+	}
+
+	// The most specific route names a source without a backend
+	_, err = live.Get("/mnt/test/other/grp-b")
+	if !errors.Is(err, iquota.ErrNoBackend) {
+		t.Errorf("Expected no backend error got %v", err)
+	}
+}
+
+func TestGoverningQuotas(t *testing.T) {
+	live, backend := newTestLiveQuotas(t, &iquota.Route{Prefix: "/", Backend: "test"})
+	close(backend.release)
+	backend.quotas = map[string]bool{"/test/grp-a": true, "/test/grp-a/nested": true}
+
+	conf := *Conf()
+	conf.ReadThrough = true
+	config.Store(&conf)
+
+	h := &Handler{backends: live.backends, live: live}
+	quotas, err := h.governingQuotas("/test/grp-a/nested/run/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, q := range quotas {
+		paths = append(paths, q.Path)
+	}
+	if got := strings.Join(paths, ","); got != "/test/grp-a/nested,/test/grp-a" {
+		t.Errorf("Wrong governing quotas: %s", got)
+	}
+
+	_, err = h.governingQuotas("/test/grp-b")
+	if !errors.Is(err, iquota.ErrNotFound) {
+		t.Errorf("Expected not found error got %v", err)
+	}
+}
+
+func TestGoverningQuotasBounded(t *testing.T) {
+	live, backend := newTestLiveQuotas(t)
+	close(backend.release)
+	backend.quotas = map[string]bool{}
+
+	conf := *Conf()
+	conf.ReadThrough = true
+	config.Store(&conf)
+
+	h := &Handler{backends: live.backends, live: live}
+
+	tests := []struct {
+		path  string
+		calls int32
+	}{
+		// Stops at the /test route prefix
+		{"/test/grp-a/nested/run", 4},
+		{"/test", 1},
+		{"/test" + strings.Repeat("/d", 30), MaxGoverningDepth},
+		// No route so only the cache, which is down here, is searched
+		{"/other/grp-a", 0},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&backend.calls, 0)
+		h.governingQuotas(test.path)
+		if n := atomic.LoadInt32(&backend.calls); n != test.calls {
+			t.Errorf("%s: expected %d backend queries got %d", test.path, test.calls, n)
+		}
+	}
+}
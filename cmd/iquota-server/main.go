@@ -11,6 +11,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	_ "github.com/ubccr/iquota/vast"
 	"github.com/urfave/cli"
 )
 
@@ -18,10 +19,7 @@ func init() {
 	viper.SetConfigName("iquota")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("/etc/iquota/")
-	viper.SetDefault("enable_cache", false)
-	viper.SetDefault("redis", ":6379")
-	viper.SetDefault("cache_expire", 500)
-	viper.SetDefault("neg_cache_expire", 86400)
+	setDefaults(viper.GetViper())
 }
 
 func main() {
@@ -51,8 +49,18 @@ func main() {
 			return fmt.Errorf("Failed reading config file - %s", err)
 		}
 
+		err = LoadConfig()
+		if err != nil {
+			return fmt.Errorf("Invalid config file - %s", err)
+		}
+
 		return nil
 	}
+	app.Commands = []cli.Command{
+		onDemandCommand(),
+		xdmodCommand(),
+		notifyCommand(),
+	}
 	app.Action = func(c *cli.Context) {
 		err := RunServer()
 		if err != nil {
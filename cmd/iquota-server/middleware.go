@@ -5,31 +5,63 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 	"github.com/ubccr/kerby"
 )
 
 var (
 	negotiateHeader = "Negotiate"
+
+	// Session cookies issued after successful SPNEGO authentication
+	sessions *SessionStore
 )
 
-// Kerberos SPNEGO authentication
+// InitAuth configures the Kerberos keytab and session cookies. Must be called
+// once at startup before serving any requests
+func InitAuth(keytab string, secure bool) error {
+	if len(keytab) == 0 {
+		return errors.New("No keytab configured")
+	}
+
+	err := os.Setenv("KRB5_KTNAME", keytab)
+	if err != nil {
+		return err
+	}
+
+	sessions, err = NewSessionStore(secure)
+	return err
+}
+
+// Kerberos SPNEGO authentication. If the request has no Authorization header
+// a valid session cookie is accepted instead.
 func KerbAuthRequired(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		authReq := strings.Split(c.Request().Header.Get(echo.HeaderAuthorization), " ")
 		if len(authReq) != 2 || authReq[0] != negotiateHeader {
+			if sessions.Enabled() {
+				user, err := sessions.Verify(c)
+				if err == nil {
+					c.Set("user", user)
+					return next(c)
+				}
+
+				if !errors.Is(err, http.ErrNoCookie) {
+					logrus.WithFields(logrus.Fields{
+						"err": err.Error(),
+					}).Info("Session cookie rejected")
+				}
+			}
+
 			c.Response().Header().Set(echo.HeaderWWWAuthenticate, negotiateHeader)
 			return echo.ErrUnauthorized
 		}
 
-		os.Setenv("KRB5_KTNAME", viper.GetString("keytab"))
-
 		ks := new(kerby.KerbServer)
 		err := ks.Init("")
 		if err != nil {
@@ -52,11 +84,21 @@ func KerbAuthRequired(next echo.HandlerFunc) echo.HandlerFunc {
 
 		princ := ks.UserName()
 		parts := strings.SplitN(princ, "@", 2)
-		user := &User{UID: parts[0]}
+		user := &User{UID: parts[0], Principal: princ}
 
 		user.Groups, err = FetchGroups(user.UID)
 		if err != nil {
-			logrus.Error("Failed to fetch groups for user: %s", user.UID)
+			logrus.Errorf("Failed to fetch groups for user: %s", user.UID)
+		} else if sessions.Enabled() {
+			// Only issue a session if we have the user's groups otherwise
+			// they would be missing for the lifetime of the session
+			err = sessions.Issue(c, user)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"err": err.Error(),
+					"uid": user.UID,
+				}).Error("Failed to issue session cookie")
+			}
 		}
 
 		c.Set("user", user)
@@ -5,13 +5,15 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/godbus/dbus"
-	"github.com/spf13/viper"
 )
 
 type User struct {
-	UID    string   `json:"uid"`
-	Groups []string `json:"groups"`
+	UID       string   `json:"uid"`
+	Groups    []string `json:"groups"`
+	Principal string   `json:"-"`
 }
 
 func (u *User) HasGroup(group string) bool {
@@ -25,7 +27,7 @@ func (u *User) HasGroup(group string) bool {
 }
 
 func (u *User) IsAdmin() bool {
-	for _, x := range viper.GetStringSlice("admins") {
+	for _, x := range Conf().Admins {
 		if x == u.UID {
 			return true
 		} else if u.HasGroup(x) {
@@ -36,6 +38,60 @@ func (u *User) IsAdmin() bool {
 	return false
 }
 
+// Returns true if user is a manager of group. If no managers are configured
+// for the group all members are considered managers.
+func (u *User) IsGroupManager(group string) bool {
+	if !u.HasGroup(group) {
+		return false
+	}
+
+	managers, ok := Conf().GroupManagers[group]
+	if !ok || len(managers) == 0 {
+		return true
+	}
+
+	for _, m := range managers {
+		if m == u.UID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if user owns path and may request changes to its quota. Users
+// own their home directory and group directories are owned by the group
+// managers. Paths that aren't a home or group directory have no owner.
+func (u *User) OwnsPath(path string) bool {
+	owner := PathOwner(path)
+	if owner == nil {
+		return false
+	}
+	if !owner.Group {
+		return owner.Name == u.UID
+	}
+
+	return u.IsGroupManager(owner.Name)
+}
+
+// Returns true if user may view the quota on path. Users can view their home
+// directory and the directories of any group they belong to.
+func (u *User) CanView(path string) bool {
+	if u.IsAdmin() {
+		return true
+	}
+
+	owner := PathOwner(path)
+	if owner == nil {
+		return false
+	}
+	if !owner.Group {
+		return owner.Name == u.UID
+	}
+
+	return u.HasGroup(owner.Name)
+}
+
 func FetchGroups(uid string) ([]string, error) {
 	conn, err := dbus.SystemBus()
 	if err != nil {
@@ -52,3 +108,49 @@ func FetchGroups(uid string) ([]string, error) {
 
 	return groups, nil
 }
+
+// FetchGroupMembers returns the uids of all members of group from sssd
+func FetchGroupMembers(group string) ([]string, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := conn.Object("org.freedesktop.sssd.infopipe", dbus.ObjectPath("/org/freedesktop/sssd/infopipe/Groups"))
+
+	var groupPath dbus.ObjectPath
+	err = obj.Call("org.freedesktop.sssd.infopipe.Groups.FindByName", 0, group).Store(&groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	groupObj := conn.Object("org.freedesktop.sssd.infopipe", groupPath)
+	err = groupObj.Call("org.freedesktop.sssd.infopipe.Groups.Group.UpdateMemberList", 0).Err
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := groupObj.GetProperty("org.freedesktop.sssd.infopipe.Groups.Group.users")
+	if err != nil {
+		return nil, err
+	}
+
+	userPaths, ok := v.Value().([]dbus.ObjectPath)
+	if !ok {
+		return nil, fmt.Errorf("Invalid member list for group %s", group)
+	}
+
+	members := make([]string, 0, len(userPaths))
+	for _, p := range userPaths {
+		name, err := conn.Object("org.freedesktop.sssd.infopipe", p).GetProperty("org.freedesktop.sssd.infopipe.Users.User.name")
+		if err != nil {
+			return nil, err
+		}
+
+		if uid, ok := name.Value().(string); ok {
+			members = append(members, uid)
+		}
+	}
+
+	return members, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestOwnsPath(t *testing.T) {
+	config.Store(&Config{
+		HomeDir:       "/home",
+		GroupDirs:     []string{"/projects"},
+		GroupManagers: map[string][]string{"grp-managed": {"alice"}},
+	})
+
+	bob := &User{UID: "bob", Groups: []string{"grp-a", "grp-managed"}}
+
+	tests := []struct {
+		path string
+		owns bool
+		view bool
+	}{
+		{"/home/bob", true, true},
+		{"/home/bob/", true, true},
+		{"/home/alice", false, false},
+		{"/projects/grp-a", true, true},
+		{"/projects/grp-b", false, false},
+		{"/projects/grp-managed", false, true},
+		{"/home/alice/grp-a", false, false},
+		{"/scratch/grp-a", false, false},
+		{"/projects/grp-a/sub", false, false},
+		{"/home/bob/data", false, false},
+		{"/grp-a", false, false},
+		{"/", false, false},
+	}
+
+	for _, test := range tests {
+		if got := bob.OwnsPath(test.path); got != test.owns {
+			t.Errorf("OwnsPath(%q) = %t, want %t", test.path, got, test.owns)
+		}
+		if got := bob.CanView(test.path); got != test.view {
+			t.Errorf("CanView(%q) = %t, want %t", test.path, got, test.view)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Owner of a directory quota. Either a user (home directories) or a group
+type Owner struct {
+	Name  string
+	Group bool
+}
+
+// PathOwner returns the owner of path. Directories directly under home_dir are
+// owned by the user they are named after and directories directly under one of
+// the group_dirs by the group they are named after. Returns nil for any other
+// path.
+func PathOwner(path string) *Owner {
+	path = filepath.Clean(path)
+	parent := filepath.Dir(path)
+	if parent == path {
+		return nil
+	}
+
+	conf := Conf()
+	if parent == conf.HomeDir {
+		return &Owner{Name: filepath.Base(path)}
+	}
+
+	for _, dir := range conf.GroupDirs {
+		if parent == dir {
+			return &Owner{Name: filepath.Base(path), Group: true}
+		}
+	}
+
+	return nil
+}
+
+// Sends notices about their quotas to directory owners
+type Notifier interface {
+	Notify(owner *Owner, subject, message string) error
+}
+
+// Notifier that only writes notices to the server log
+type logNotifier struct{}
+
+func (n *logNotifier) Notify(owner *Owner, subject, message string) error {
+	log.WithFields(log.Fields{
+		"owner": owner.Name,
+		"group": owner.Group,
+	}).Warnf("%s: %s", subject, message)
+
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+	"github.com/urfave/cli"
+)
+
+const (
+	OnDemandVersion = 1
+
+	OnDemandTypeUser    = "user"
+	OnDemandTypeFileset = "fileset"
+)
+
+// Quota entry in the Open OnDemand quota file format. Block sizes are in KB.
+// OnDemand only shows entries whose user matches the logged in user so group
+// directories have one fileset entry per group member.
+type OnDemandQuota struct {
+	Type            string `json:"type"`
+	User            string `json:"user"`
+	Path            string `json:"path"`
+	BlockUsage      int    `json:"block_usage"`
+	TotalBlockUsage int    `json:"total_block_usage"`
+	BlockLimit      int    `json:"block_limit"`
+	FileUsage       int    `json:"file_usage"`
+	TotalFileUsage  int    `json:"total_file_usage"`
+	FileLimit       int    `json:"file_limit"`
+}
+
+// Open OnDemand quota file
+type OnDemandFeed struct {
+	Version   int              `json:"version"`
+	Timestamp int64            `json:"timestamp"`
+	Quotas    []*OnDemandQuota `json:"quotas"`
+}
+
+// Returns the limit OnDemand should warn against, the soft limit if set
+// otherwise the hard limit
+func onDemandLimit(soft, hard int) int {
+	if soft > 0 {
+		return soft
+	}
+
+	return hard
+}
+
+// Returns the OnDemand quota entry of q for user. Usage of individual users
+// in group directories is not tracked so fileset entries only report the
+// total usage.
+func newOnDemandQuota(q *iquota.Quota, user string, fileset bool) *OnDemandQuota {
+	odq := &OnDemandQuota{
+		Type:            OnDemandTypeUser,
+		User:            user,
+		Path:            q.Path,
+		TotalBlockUsage: q.Used / 1024,
+		BlockLimit:      onDemandLimit(q.SoftLimit, q.HardLimit) / 1024,
+		TotalFileUsage:  q.UsedInodes,
+		FileLimit:       onDemandLimit(q.SoftLimitInodes, q.HardLimitInodes),
+	}
+
+	if fileset {
+		odq.Type = OnDemandTypeFileset
+	} else {
+		odq.BlockUsage = odq.TotalBlockUsage
+		odq.FileUsage = odq.TotalFileUsage
+	}
+
+	return odq
+}
+
+// BuildOnDemand returns the OnDemand quota feed for quotas. Home directories
+// are reported for their user and group directories for each uid returned by
+// members. Quotas without any limits are skipped.
+func BuildOnDemand(quotas []*iquota.Quota, members func(group string) ([]string, error)) *OnDemandFeed {
+	feed := &OnDemandFeed{
+		Version:   OnDemandVersion,
+		Timestamp: time.Now().Unix(),
+		Quotas:    make([]*OnDemandQuota, 0),
+	}
+
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Path < quotas[j].Path })
+
+	for _, q := range quotas {
+		if q.SoftLimit == 0 && q.HardLimit == 0 && q.SoftLimitInodes == 0 && q.HardLimitInodes == 0 {
+			continue
+		}
+
+		owner := PathOwner(q.Path)
+		if owner == nil {
+			continue
+		}
+		if !owner.Group {
+			feed.Quotas = append(feed.Quotas, newOnDemandQuota(q, owner.Name, false))
+			continue
+		}
+
+		uids, err := members(owner.Name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":   err,
+				"path":  q.Path,
+				"group": owner.Name,
+			}).Warn("Failed to fetch group members, skipping quota")
+			continue
+		}
+
+		for _, uid := range uids {
+			feed.Quotas = append(feed.Quotas, newOnDemandQuota(q, uid, true))
+		}
+	}
+
+	return feed
+}
+
+// Builds the OnDemand feed for all cached quotas looking up group members in
+// sssd
+func buildOnDemandAll() (*OnDemandFeed, error) {
+	quotas, err := Conf().Cache().SearchDirectoryQuotaCache("")
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	return BuildOnDemand(quotas, func(group string) ([]string, error) {
+		if uids, ok := groups[group]; ok {
+			return uids, nil
+		}
+
+		uids, err := FetchGroupMembers(group)
+		if err != nil {
+			return nil, err
+		}
+
+		groups[group] = uids
+		return uids, nil
+	}), nil
+}
+
+// Returns the Open OnDemand quota feed. Admins get all quotas and other users
+// only the entries for their home and group directories.
+func (h *Handler) OnDemand(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+	log.Infof("User %s requesting OnDemand quotas", user.UID)
+
+	if user.IsAdmin() {
+		feed, err := buildOnDemandAll()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("Failed to build OnDemand quotas")
+
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quotas")
+		}
+
+		return c.JSON(http.StatusOK, feed)
+	}
+
+	quotas, err := Conf().Cache().SearchDirectoryQuotaCache("")
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to fetch quotas for OnDemand")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quotas")
+	}
+
+	visible := make([]*iquota.Quota, 0)
+	for _, q := range quotas {
+		if user.CanView(q.Path) {
+			visible = append(visible, q)
+		}
+	}
+
+	feed := BuildOnDemand(visible, func(group string) ([]string, error) {
+		return []string{user.UID}, nil
+	})
+
+	return c.JSON(http.StatusOK, feed)
+}
+
+// WriteOnDemand writes the OnDemand quota feed for all cached quotas to file.
+// The file is replaced atomically so OnDemand never reads a partial file.
+func WriteOnDemand(file string) error {
+	feed, err := buildOnDemandAll()
+	if err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(feed)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, out)
+}
+
+// Writes data to file by renaming a temp file in the same directory so
+// readers never see a partially written file
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".iquota-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+func onDemandCommand() cli.Command {
+	return cli.Command{
+		Name:  "ondemand",
+		Usage: "Write quotas in Open OnDemand quota file format",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "output,o", Usage: "Path to output file"},
+		},
+		Action: func(c *cli.Context) error {
+			file := c.String("output")
+			if len(file) == 0 {
+				return errors.New("Please provide an output file with --output")
+			}
+
+			err := WriteOnDemand(file)
+			if err != nil {
+				return fmt.Errorf("Failed to write OnDemand quotas - %s", err)
+			}
+
+			return nil
+		},
+	}
+}
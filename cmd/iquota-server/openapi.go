@@ -0,0 +1,23 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpenAPI document describing the iquota-server API. Kept in sync with the
+// routes in SetupRoutes by TestOpenAPIRoutes
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Serve the OpenAPI document
+func OpenAPI(c echo.Context) error {
+	return c.Blob(http.StatusOK, "application/yaml", openAPISpec)
+}
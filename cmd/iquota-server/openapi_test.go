@@ -0,0 +1,119 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v2"
+)
+
+// Routes that serve the web UI and are not part of the API
+var undocumentedRoutes = map[string]bool{
+	"GET /":     true,
+	"GET /ui/*": true,
+}
+
+var routeParam = regexp.MustCompile(`:([^/]+)`)
+
+type openAPIOperation struct {
+	OperationID string                 `yaml:"operationId"`
+	Responses   map[string]interface{} `yaml:"responses"`
+}
+
+type openAPIDoc struct {
+	OpenAPI string                                  `yaml:"openapi"`
+	Paths   map[string]map[string]*openAPIOperation `yaml:"paths"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	doc := &openAPIDoc{}
+	err := yaml.Unmarshal(openAPISpec, doc)
+	if err != nil {
+		t.Fatalf("Invalid openapi.yaml: %s", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("Expected an OpenAPI 3 document got version %q", doc.OpenAPI)
+	}
+
+	return doc
+}
+
+// Returns the routes registered by SetupRoutes as "METHOD /path" with echo
+// path params converted to OpenAPI templates
+func echoRoutes() []string {
+	e := echo.New()
+	h := &Handler{}
+	h.SetupRoutes(e)
+
+	routes := make([]string, 0)
+	for _, r := range e.Routes() {
+		route := r.Method + " " + r.Path
+		if undocumentedRoutes[route] {
+			continue
+		}
+
+		routes = append(routes, r.Method+" "+routeParam.ReplaceAllString(r.Path, "{$1}"))
+	}
+
+	sort.Strings(routes)
+	return routes
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	documented := make(map[string]bool)
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			route := strings.ToUpper(method) + " " + path
+			documented[route] = true
+
+			if op == nil || len(op.OperationID) == 0 {
+				t.Errorf("%s is missing an operationId", route)
+			}
+			if op == nil || len(op.Responses) == 0 {
+				t.Errorf("%s has no responses", route)
+			}
+		}
+	}
+
+	for _, route := range echoRoutes() {
+		if !documented[route] {
+			t.Errorf("%s is served but not in openapi.yaml", route)
+		}
+		delete(documented, route)
+	}
+
+	for route := range documented {
+		t.Errorf("%s is in openapi.yaml but not served", route)
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	var doc map[string]interface{}
+	err := yaml.Unmarshal(openAPISpec, &doc)
+	if err != nil {
+		t.Fatalf("Invalid openapi.yaml: %s", err)
+	}
+
+	refs := regexp.MustCompile(`\$ref: "#/components/([a-zA-Z]+)/([a-zA-Z]+)"`).FindAllStringSubmatch(string(openAPISpec), -1)
+	if len(refs) == 0 {
+		t.Fatal("No refs found in openapi.yaml")
+	}
+
+	components, _ := doc["components"].(map[interface{}]interface{})
+	for _, ref := range refs {
+		section, _ := components[ref[1]].(map[interface{}]interface{})
+		if _, ok := section[ref[2]]; !ok {
+			t.Errorf("Unresolved ref #/components/%s/%s", ref[1], ref[2])
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// Token bucket settings for a rate limiter
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func newRateLimit(key string) *rateLimit {
+	return &rateLimit{
+		Rate:  viper.GetFloat64(key + ".rate"),
+		Burst: viper.GetInt(key + ".burst"),
+	}
+}
+
+func (rl *rateLimit) enabled() bool {
+	return rl.Rate > 0
+}
+
+// Seconds until a new token is added to the bucket
+func (rl *rateLimit) retryAfter() string {
+	return fmt.Sprintf("%d", int(math.Ceil(1/rl.Rate)))
+}
+
+func (rl *rateLimit) store() middleware.RateLimiterStore {
+	return middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+		Rate:      rate.Limit(rl.Rate),
+		Burst:     rl.Burst,
+		ExpiresIn: 10 * time.Minute,
+	})
+}
+
+func (rl *rateLimit) deny(kind string) func(echo.Context, string, error) error {
+	return func(c echo.Context, identifier string, err error) error {
+		log.WithFields(log.Fields{
+			kind:  identifier,
+			"uri": c.Request().RequestURI,
+		}).Warn("Rate limit exceeded")
+
+		c.Response().Header().Set("Retry-After", rl.retryAfter())
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests")
+	}
+}
+
+// Returns true if ip is in the list of exempt IPs/networks
+func rateLimitExempt(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, x := range viper.GetStringSlice("rate_limit.exempt") {
+		if strings.Contains(x, "/") {
+			_, cidr, err := net.ParseCIDR(x)
+			if err == nil && addr != nil && cidr.Contains(addr) {
+				return true
+			}
+		} else if x == ip {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rate limit requests per source IP. This runs before authentication so
+// floods are rejected before doing a GSSAPI handshake.
+func IPRateLimit() echo.MiddlewareFunc {
+	rl := newRateLimit("rate_limit.ip")
+	if !rl.enabled() {
+		return passThrough
+	}
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			return rateLimitExempt(c.RealIP())
+		},
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		Store:       rl.store(),
+		DenyHandler: rl.deny("ip"),
+	})
+}
+
+// Rate limit requests per authenticated principal. Admins are exempt. Must
+// run after KerbAuthRequired.
+func UserRateLimit() echo.MiddlewareFunc {
+	rl := newRateLimit("rate_limit.user")
+	if !rl.enabled() {
+		return passThrough
+	}
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			user, ok := c.Get("user").(*User)
+			return ok && user.IsAdmin()
+		},
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			user, ok := c.Get("user").(*User)
+			if !ok {
+				return "", errors.New("Failed to get user")
+			}
+			return user.UID, nil
+		},
+		Store:       rl.store(),
+		DenyHandler: rl.deny("uid"),
+	})
+}
+
+func passThrough(next echo.HandlerFunc) echo.HandlerFunc {
+	return next
+}
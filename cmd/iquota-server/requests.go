@@ -0,0 +1,319 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+)
+
+// Fetch the request from the :id path param. Users may only view their own
+// requests
+func (h *Handler) fetchRequest(c echo.Context, user *User) (*iquota.QuotaRequest, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid request id")
+	}
+
+	r, err := Conf().Cache().GetRequest(id)
+	if err != nil {
+		if errors.Is(err, iquota.ErrNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, nil)
+		}
+
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("Failed to fetch quota request")
+
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get quota request")
+	}
+
+	if r.User != user.UID && !user.IsAdmin() {
+		return nil, echo.ErrUnauthorized
+	}
+
+	return r, nil
+}
+
+func (h *Handler) SubmitRequest(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	var req iquota.QuotaRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quota request")
+	}
+
+	path := filepath.Clean(req.Path)
+	if !filepath.IsAbs(path) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Path must be absolute")
+	}
+
+	if req.SoftLimit <= 0 || req.SoftLimitInodes < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quota limit")
+	}
+
+	if len(strings.TrimSpace(req.Justification)) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Justification is required")
+	}
+
+	log.Infof("User %s requesting quota increase for %s", user.UID, path)
+
+	if !user.OwnsPath(path) && !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	if _, err := h.backends.ForPath(path); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Quota changes are not supported for path")
+	}
+
+	cache := Conf().Cache()
+
+	quota, err := h.lookupQuota(path)
+	if err != nil {
+		if errors.Is(err, iquota.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "No quota found for path")
+		}
+
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Error("Failed to fetch quota for request")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to submit quota request")
+	}
+
+	// Requests may only raise limits. A zero inode limit keeps the current one
+	if req.SoftLimit < quota.SoftLimit || (req.SoftLimitInodes > 0 && req.SoftLimitInodes < quota.SoftLimitInodes) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Requested limits must not be below the current limits")
+	}
+
+	if req.SoftLimit == quota.SoftLimit && req.SoftLimitInodes <= quota.SoftLimitInodes {
+		return echo.NewHTTPError(http.StatusBadRequest, "Requested limit must be larger than the current limit")
+	}
+
+	pending, err := cache.ListRequests(iquota.RequestPending)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to list pending quota requests")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to submit quota request")
+	}
+
+	for _, p := range pending {
+		if p.Path == path {
+			return echo.NewHTTPError(http.StatusConflict, "A request for this path is already pending")
+		}
+	}
+
+	r := &iquota.QuotaRequest{
+		Path:                   path,
+		User:                   user.UID,
+		Justification:          strings.TrimSpace(req.Justification),
+		SoftLimit:              req.SoftLimit,
+		SoftLimitInodes:        req.SoftLimitInodes,
+		CurrentSoftLimit:       quota.SoftLimit,
+		CurrentSoftLimitInodes: quota.SoftLimitInodes,
+	}
+
+	err = cache.CreateRequest(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Error("Failed to save quota request")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to submit quota request")
+	}
+
+	log.WithFields(log.Fields{
+		"id":   r.ID,
+		"uid":  user.UID,
+		"path": path,
+	}).Warn("Quota increase requested")
+
+	return c.JSON(http.StatusCreated, r)
+}
+
+func (h *Handler) ListRequests(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+	log.Infof("User %s listing quota requests", user.UID)
+
+	status := c.QueryParam("status")
+	switch status {
+	case "", iquota.RequestPending, iquota.RequestApproved, iquota.RequestDenied:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid status. Must be one of pending, approved or denied")
+	}
+
+	requests, err := Conf().Cache().ListRequests(status)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to list quota requests")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list quota requests")
+	}
+
+	if !user.IsAdmin() {
+		mine := make([]*iquota.QuotaRequest, 0)
+		for _, r := range requests {
+			if r.User == user.UID {
+				mine = append(mine, r)
+			}
+		}
+		requests = mine
+	}
+
+	return c.JSON(http.StatusOK, requests)
+}
+
+func (h *Handler) GetRequest(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	r, err := h.fetchRequest(c, user)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, r)
+}
+
+// Record the admin decision on a pending request. If approved the new limits
+// are applied through the storage backend first.
+func (h *Handler) decideRequest(c echo.Context, status string) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	r, err := h.fetchRequest(c, user)
+	if err != nil {
+		return err
+	}
+
+	var decision iquota.RequestDecision
+	if err := c.Bind(&decision); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid decision")
+	}
+
+	cache := Conf().Cache()
+
+	// Only one admin may decide a request at a time. The lock outlives the
+	// backend timeout in case the server dies mid change
+	id := r.ID
+	lock := "request:" + strconv.FormatInt(id, 10)
+	token, ok, err := cache.AcquireLock(lock, time.Duration(Conf().BackendTimeout)*time.Second+time.Minute)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("Failed to lock quota request")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save decision")
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusConflict, "Request is being reviewed by another admin")
+	}
+	defer cache.ReleaseLock(lock, token)
+
+	// Re-read the request now that it is locked in case it was just decided
+	r, err = cache.GetRequest(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("Failed to fetch quota request")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save decision")
+	}
+
+	if r.Status != iquota.RequestPending {
+		return echo.NewHTTPError(http.StatusConflict, "Request has already been "+r.Status)
+	}
+
+	if status == iquota.RequestApproved {
+		backend, err := h.backends.ForPath(r.Path)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Quota changes are not supported for path")
+		}
+
+		current, err := backend.GetQuota(r.Path)
+		if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"path":    r.Path,
+				"backend": backend.Name(),
+			}).Error("Failed to fetch quota from storage backend")
+
+			return echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch current quota")
+		}
+
+		err = applyQuota(backend, r.Path, r.Limits(current))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadGateway, "Failed to apply quota change")
+		}
+	}
+
+	now := time.Now()
+	r.Status = status
+	r.Reviewer = user.UID
+	r.Reviewed = &now
+	r.Comment = strings.TrimSpace(decision.Comment)
+
+	err = cache.UpdateRequest(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"id":     r.ID,
+			"status": status,
+		}).Error("Failed to save quota request decision")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save decision")
+	}
+
+	log.WithFields(log.Fields{
+		"id":       r.ID,
+		"path":     r.Path,
+		"status":   status,
+		"reviewer": user.UID,
+	}).Warn("Quota request reviewed")
+
+	return c.JSON(http.StatusOK, r)
+}
+
+func (h *Handler) ApproveRequest(c echo.Context) error {
+	return h.decideRequest(c, iquota.RequestApproved)
+}
+
+func (h *Handler) DenyRequest(c echo.Context) error {
+	return h.decideRequest(c, iquota.RequestDenied)
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSubmitRequestLimits(t *testing.T) {
+	live, backend := newTestLiveQuotas(t)
+	close(backend.release)
+	backend.inodes = 1000
+
+	conf := *Conf()
+	conf.ReadThrough = true
+	conf.GroupDirs = []string{"/test"}
+	config.Store(&conf)
+
+	h := &Handler{backends: live.backends, live: live}
+
+	// Current quota is a soft limit of 100 bytes and 1000 files
+	tests := []struct {
+		body     string
+		rejected bool
+	}{
+		{`{"soft_limit": 200}`, false},
+		{`{"soft_limit": 100, "soft_limit_inodes": 2000}`, false},
+		{`{"soft_limit": 200, "soft_limit_inodes": 1000}`, false},
+		{`{"soft_limit": 100}`, true},
+		{`{"soft_limit": 100, "soft_limit_inodes": 1000}`, true},
+		// Raising one limit can't be used to lower the other
+		{`{"soft_limit": 1, "soft_limit_inodes": 2000}`, true},
+		{`{"soft_limit": 200, "soft_limit_inodes": 10}`, true},
+		{`{"soft_limit": 0}`, true},
+	}
+
+	for _, test := range tests {
+		body := `{"path": "/test/grp-a", "justification": "more data", ` + strings.TrimPrefix(test.body, "{")
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/requests", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.Set("user", &User{UID: "bob", Groups: []string{"grp-a"}})
+
+		// Accepted requests fail later saving to redis which isn't running
+		err := h.SubmitRequest(c)
+		he, ok := err.(*echo.HTTPError)
+		rejected := ok && he.Code == http.StatusBadRequest
+		if rejected != test.rejected {
+			t.Errorf("%s: expected rejected %t got %v", test.body, test.rejected, err)
+		}
+	}
+}
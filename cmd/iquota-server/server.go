@@ -5,9 +5,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -16,17 +22,25 @@ import (
 	"github.com/spf13/viper"
 )
 
-func init() {
-	viper.SetDefault("port", 8080)
-	viper.SetDefault("home_dir", "/home")
-}
-
 // Start web server
 func RunServer() error {
 	e := echo.New()
 	e.HideBanner = true
 	e.Use(middleware.Recover())
 
+	// Only trust X-Forwarded-For when running behind a proxy. Otherwise
+	// clients could spoof their IP to get around rate limits
+	if viper.GetBool("trust_proxy_headers") {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	err := InitAuth(viper.GetString("keytab"), Conf().TLSEnabled())
+	if err != nil {
+		return err
+	}
+
 	h, err := NewHandler()
 	if err != nil {
 		return err
@@ -41,9 +55,24 @@ func RunServer() error {
 		IdleTimeout:  120 * time.Second,
 	}
 
-	certFile := viper.GetString("cert")
-	keyFile := viper.GetString("key")
-	if certFile != "" && keyFile != "" {
+	// End event streams so shutdown doesn't wait on them
+	s.RegisterOnShutdown(h.events.Close)
+
+	listener, err := systemdListener()
+	if err != nil {
+		return fmt.Errorf("Failed to get systemd socket - %s", err)
+	}
+
+	if listener == nil {
+		listener, err = net.Listen("tcp", s.Addr)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Infof("Using socket passed by systemd: %s", listener.Addr())
+	}
+
+	if Conf().TLSEnabled() {
 		cfg := &tls.Config{
 			MinVersion: tls.VersionTLS12,
 			CurvePreferences: []tls.CurveID{
@@ -61,18 +90,71 @@ func RunServer() error {
 			},
 		}
 
-		s.TLSConfig = cfg
-		s.TLSConfig.Certificates = make([]tls.Certificate, 1)
-		s.TLSConfig.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			return err
+		// Always use the certificate from the current config so it can be
+		// reloaded without a restart
+		cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			conf := Conf()
+			if !conf.TLSEnabled() {
+				return nil, errors.New("No TLS certificate configured")
+			}
+			return conf.certificate, nil
 		}
 
-		log.Printf("Running on https://%s:%d%s", viper.GetString("bind"), viper.GetInt("port"), "/")
+		s.TLSConfig = cfg
+		e.TLSListener = tls.NewListener(listener, cfg)
+
+		log.Printf("Running on https://%s%s", listener.Addr(), "/")
 	} else {
 		log.Warn("**WARNING*** SSL/TLS not enabled. HTTP communication will not be encrypted and vulnerable to snooping.")
-		log.Printf("Running on http://%s:%d%s", viper.GetString("bind"), viper.GetInt("port"), "/")
+		e.Listener = listener
+		log.Printf("Running on http://%s%s", listener.Addr(), "/")
+	}
+
+	WatchConfig()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.StartServer(s)
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	systemdNotify(sdNotifyReady)
+	go systemdWatchdog(done)
+	go h.RunTempScheduler(done)
+	go h.events.Run(done)
+	go h.alerts.Run(done)
+	go h.email.Run(done)
+	h.collectors.Run(done)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, os.Interrupt)
+
+	return waitShutdown(s, errc, quit, time.Duration(viper.GetInt("shutdown_timeout"))*time.Second)
+}
+
+// Wait for the server to exit or a signal on quit. On a signal stop accepting
+// new connections and wait for in-flight requests to finish up to timeout.
+func waitShutdown(s *http.Server, errc <-chan error, quit <-chan os.Signal, timeout time.Duration) error {
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-quit:
+		log.Warnf("Received %s, shutting down", sig)
+	}
+
+	systemdNotify(sdNotifyStopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// e.Shutdown only stops the servers echo created itself so shut down s
+	// directly. This also runs the RegisterOnShutdown hooks
+	err := s.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to gracefully shutdown - %s", err)
 	}
 
-	return e.StartServer(s)
+	return nil
 }
@@ -0,0 +1,92 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	started := make(chan struct{})
+	e.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		return c.String(http.StatusOK, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Listener = listener
+
+	s := &http.Server{}
+	var hooked int32
+	s.RegisterOnShutdown(func() { atomic.StoreInt32(&hooked, 1) })
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.StartServer(s)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		resc <- result{string(body), err}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slow request never started")
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	err = waitShutdown(s, errc, quit, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-resc
+	if res.err != nil || res.body != "done" {
+		t.Errorf("In-flight request not drained: %q %v", res.body, res.err)
+	}
+
+	if atomic.LoadInt32(&hooked) != 1 {
+		t.Error("Shutdown hooks did not run")
+	}
+
+	if _, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second); err == nil {
+		t.Error("Server still accepting connections after shutdown")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	SessionCookieName = "iquota_session"
+)
+
+var (
+	ErrInvalidSession = errors.New("invalid session")
+	ErrExpiredSession = errors.New("expired session")
+)
+
+// Contents of a session cookie
+type session struct {
+	UID       string   `json:"uid"`
+	Principal string   `json:"principal"`
+	Groups    []string `json:"groups"`
+	Expires   int64    `json:"exp"`
+}
+
+// Issues and verifies HMAC signed session cookies. After a successful SPNEGO
+// handshake the server issues a short lived cookie carrying the user's uid
+// and groups so subsequent requests can skip Kerberos authentication.
+type SessionStore struct {
+	key    []byte
+	ttl    time.Duration
+	secure bool
+}
+
+// NewSessionStore creates a new session store from the session config. If
+// no secret is configured a random one is generated, in which case sessions
+// will not survive a restart or be shared between multiple servers.
+func NewSessionStore(secure bool) (*SessionStore, error) {
+	s := &SessionStore{
+		ttl:    time.Duration(viper.GetInt("session.ttl")) * time.Second,
+		secure: secure,
+	}
+
+	secretFile := viper.GetString("session.secret_file")
+	if len(secretFile) > 0 {
+		secret, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read session secret file: %s", err)
+		}
+
+		s.key = []byte(strings.TrimSpace(string(secret)))
+	}
+
+	if len(s.key) == 0 {
+		log.Warn("No session secret_file configured, generating random secret")
+		s.key = make([]byte, 32)
+		_, err := rand.Read(s.key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.key) < 32 {
+		return nil, errors.New("Session secret must be at least 32 bytes")
+	}
+
+	return s, nil
+}
+
+// Enabled returns true if session cookies are enabled
+func (s *SessionStore) Enabled() bool {
+	return s != nil && s.ttl > 0
+}
+
+func (s *SessionStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue sets a new session cookie for user on the response
+func (s *SessionStore) Issue(c echo.Context, user *User) error {
+	expires := time.Now().Add(s.ttl)
+	data, err := json.Marshal(&session{
+		UID:       user.UID,
+		Principal: user.Principal,
+		Groups:    user.Groups,
+		Expires:   expires.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookieName,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(s.ttl.Seconds()),
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// Verify returns the user from a valid session cookie
+func (s *SessionStore) Verify(c echo.Context) (*User, error) {
+	cookie, err := c.Cookie(SessionCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidSession
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return nil, ErrInvalidSession
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+
+	var sess session
+	err = json.Unmarshal(data, &sess)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+
+	if time.Now().Unix() >= sess.Expires {
+		return nil, ErrExpiredSession
+	}
+
+	return &User{UID: sess.UID, Principal: sess.Principal, Groups: sess.Groups}, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// First file descriptor passed by systemd socket activation
+	listenFdsStart = 3
+
+	sdNotifyReady    = "READY=1"
+	sdNotifyStopping = "STOPPING=1"
+	sdNotifyWatchdog = "WATCHDOG=1"
+)
+
+// Returns true if the systemd environment variable pidVar is unset or matches
+// the current process
+func systemdPidMatch(pidVar string) bool {
+	pid := os.Getenv(pidVar)
+	if len(pid) == 0 {
+		return true
+	}
+
+	p, err := strconv.Atoi(pid)
+	if err != nil {
+		return false
+	}
+
+	return p == os.Getpid()
+}
+
+// Returns the listener passed in by systemd socket activation or nil if the
+// server was not socket activated. See sd_listen_fds(3)
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if len(os.Getenv("LISTEN_PID")) == 0 || !systemdPidMatch("LISTEN_PID") {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds == 0 {
+		return nil, nil
+	}
+
+	if nfds > 1 {
+		log.Warnf("Received %d sockets from systemd, only using the first", nfds)
+	}
+
+	f := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_3")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid socket passed by systemd: %s", err)
+	}
+
+	return l, nil
+}
+
+// Notify systemd of state changes. This is a no-op when not running under
+// systemd with Type=notify. See sd_notify(3)
+func systemdNotify(state string) {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if len(sock) == 0 {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err == nil {
+		defer conn.Close()
+		_, err = conn.Write([]byte(state))
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":   err,
+			"state": state,
+		}).Error("Failed to notify systemd")
+	}
+}
+
+// Send watchdog keep-alive pings to systemd until done is closed. This is a
+// no-op if WatchdogSec is not set in the service unit. See
+// sd_watchdog_enabled(3)
+func systemdWatchdog(done <-chan struct{}) {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 || !systemdPidMatch("WATCHDOG_PID") {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			systemdNotify(sdNotifyWatchdog)
+		case <-done:
+			return
+		}
+	}
+}
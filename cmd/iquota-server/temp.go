@@ -0,0 +1,327 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+// Refresh the cached quota for path from the storage backend
+func refreshQuotaCache(backend iquota.Backend, path string) {
+	quota, err := backend.GetQuota(path)
+	if err == nil {
+		err = Conf().Cache().SetDirectoryQuotaCache(path, quota)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Warn("Failed to refresh cached quota")
+	}
+}
+
+func (h *Handler) ListTempIncreases(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+	log.Infof("User %s listing temporary quota increases", user.UID)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	increases, err := Conf().Cache().ListTempIncreases()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to list temporary quota increases")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list temporary quota increases")
+	}
+
+	return c.JSON(http.StatusOK, increases)
+}
+
+// Apply a temporary increase. If the path already has a temporary increase
+// the expiry and limits are updated and the original limits are kept.
+func (h *Handler) SetTempIncrease(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	var req iquota.TempIncrease
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid temporary quota increase")
+	}
+
+	path := filepath.Clean(req.Path)
+	if !filepath.IsAbs(path) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Path must be absolute")
+	}
+
+	if req.Limits.SoftLimit <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quota limit")
+	}
+
+	if !req.Expires.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Expiry must be in the future")
+	}
+
+	log.Infof("User %s setting temporary quota increase for %s", user.UID, path)
+
+	backend, err := h.backends.ForPath(path)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Quota changes are not supported for path")
+	}
+
+	current, err := backend.GetQuota(path)
+	if err != nil {
+		if errors.Is(err, iquota.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "No quota found for path")
+		}
+
+		log.WithFields(log.Fields{
+			"err":     err,
+			"path":    path,
+			"backend": backend.Name(),
+		}).Error("Failed to fetch quota from storage backend")
+
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch current quota")
+	}
+
+	cache := Conf().Cache()
+
+	t := &iquota.TempIncrease{
+		Path:     path,
+		Limits:   req.Limits,
+		Original: current.QuotaLimits(),
+		Expires:  req.Expires,
+		Created:  time.Now(),
+		User:     user.UID,
+		Reason:   strings.TrimSpace(req.Reason),
+	}
+
+	existing, err := cache.GetTempIncrease(path)
+	if err == nil {
+		t.Original = existing.Original
+	} else if !errors.Is(err, iquota.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Error("Failed to fetch temporary quota increase")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set temporary quota increase")
+	}
+
+	if t.Limits.SoftLimitInodes == 0 {
+		t.Limits.SoftLimitInodes = current.SoftLimitInodes
+		t.Limits.HardLimitInodes = current.HardLimitInodes
+	}
+
+	// Save the original limits before changing anything so they can always
+	// be restored
+	err = cache.SetTempIncrease(t)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set temporary quota increase")
+	}
+
+	err = backend.SetQuota(path, &t.Limits)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"path":    path,
+			"backend": backend.Name(),
+		}).Error("Failed to apply temporary quota increase")
+
+		if existing == nil {
+			cache.DeleteTempIncrease(path)
+		} else {
+			cache.SetTempIncrease(existing)
+		}
+
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to apply quota change")
+	}
+
+	refreshQuotaCache(backend, path)
+
+	log.WithFields(log.Fields{
+		"path":    path,
+		"user":    user.UID,
+		"expires": t.Expires,
+	}).Warn("Temporary quota increase applied")
+
+	return c.JSON(http.StatusOK, t)
+}
+
+// Revert a temporary increase now
+func (h *Handler) RevertTempIncrease(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	if !user.IsAdmin() {
+		return echo.ErrUnauthorized
+	}
+
+	path := filepath.Clean(c.QueryParam("path"))
+	log.Infof("User %s reverting temporary quota increase for %s", user.UID, path)
+
+	t, err := Conf().Cache().GetTempIncrease(path)
+	if err != nil {
+		if errors.Is(err, iquota.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, nil)
+		}
+
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Error("Failed to fetch temporary quota increase")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revert temporary quota increase")
+	}
+
+	err = h.revertTempIncrease(t)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to restore original quota")
+	}
+
+	return c.JSON(http.StatusOK, t)
+}
+
+// Restore the original limits and remove the temporary increase
+func (h *Handler) revertTempIncrease(t *iquota.TempIncrease) error {
+	backend, err := h.backends.ForPath(t.Path)
+	if err != nil {
+		return err
+	}
+
+	err = backend.SetQuota(t.Path, &t.Original)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"path":    t.Path,
+			"backend": backend.Name(),
+		}).Error("Failed to restore original quota limits")
+		return err
+	}
+
+	refreshQuotaCache(backend, t.Path)
+
+	err = Conf().Cache().DeleteTempIncrease(t.Path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": t.Path,
+		}).Error("Failed to remove temporary quota increase")
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"path": t.Path,
+	}).Warn("Temporary quota increase reverted")
+
+	return nil
+}
+
+// Revert expired temporary increases and warn owners of increases expiring
+// soon
+func (h *Handler) checkTempIncreases(interval, warnBefore time.Duration) {
+	cache := Conf().Cache()
+
+	// Only one server should revert quotas when running more than one. The
+	// lock expires just before the next run
+	_, ok, err := cache.AcquireLock("temp", interval*9/10)
+	if err != nil || !ok {
+		return
+	}
+
+	increases, err := cache.ListTempIncreases()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to list temporary quota increases")
+		return
+	}
+
+	now := time.Now()
+	for _, t := range increases {
+		owner := PathOwner(t.Path)
+
+		if !now.Before(t.Expires) {
+			if h.revertTempIncrease(t) != nil || owner == nil {
+				continue
+			}
+
+			h.notifier.Notify(owner, "Temporary quota increase expired",
+				fmt.Sprintf("The quota on %s has been restored to %s", t.Path, humanize.Bytes(uint64(t.Original.SoftLimit))))
+			continue
+		}
+
+		// Paths that aren't a home or group directory have no one to warn
+		if !t.Warned && owner != nil && now.Add(warnBefore).After(t.Expires) {
+			err := h.notifier.Notify(owner, "Temporary quota increase expiring",
+				fmt.Sprintf("The quota on %s will be restored from %s to %s on %s",
+					t.Path,
+					humanize.Bytes(uint64(t.Limits.SoftLimit)),
+					humanize.Bytes(uint64(t.Original.SoftLimit)),
+					t.Expires.Format("2006-01-02 15:04")))
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err":  err,
+					"path": t.Path,
+				}).Error("Failed to warn owner of expiring quota increase")
+				continue
+			}
+
+			t.Warned = true
+			cache.SetTempIncrease(t)
+		}
+	}
+}
+
+// RunTempScheduler periodically reverts expired temporary increases until done
+// is closed
+func (h *Handler) RunTempScheduler(done <-chan struct{}) {
+	interval := time.Duration(viper.GetInt("temp_quota.interval")) * time.Second
+	warnBefore := time.Duration(viper.GetInt("temp_quota.warn_before")) * time.Hour
+	if interval <= 0 {
+		log.Warn("Temporary quota increase scheduler disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.checkTempIncreases(interval, warnBefore)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
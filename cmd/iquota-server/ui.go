@@ -0,0 +1,127 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// Serve the embedded web UI. The UI is behind the same authentication as the
+// api so the browser negotiates once and then uses the session cookie.
+func uiHandler() echo.HandlerFunc {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+
+	fileServer := http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
+
+// Returns the current user with their home and group directory quotas
+func (h *Handler) Me(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+	log.Infof("User %s requesting dashboard", user.UID)
+
+	conf := Conf()
+	cache := conf.Cache()
+
+	d := &iquota.Dashboard{
+		UID:    user.UID,
+		Groups: user.Groups,
+		Admin:  user.IsAdmin(),
+		Quotas: make([]*iquota.Quota, 0),
+	}
+
+	quota, err := h.lookupQuota(filepath.Join(conf.HomeDir, user.UID))
+	if err == nil {
+		d.Quotas = append(d.Quotas, quota)
+	} else if !errors.Is(err, iquota.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get quota")
+	}
+
+	for _, g := range user.Groups {
+		quotas, err := cache.SearchDirectoryQuotaCache(g)
+		if err != nil {
+			if errors.Is(err, iquota.ErrNotFound) {
+				continue
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get quota")
+		}
+
+		for _, q := range quotas {
+			// Search matches by suffix so only keep directories named
+			// after the group
+			if filepath.Base(q.Path) == g {
+				d.Quotas = append(d.Quotas, q)
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, d)
+}
+
+// Returns the usage history of a directory quota
+func (h *Handler) History(c echo.Context) error {
+	u := c.Get("user")
+	if u == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	user := u.(*User)
+
+	path := c.QueryParam("path")
+	if len(path) == 0 || !filepath.IsAbs(path) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Path must be absolute")
+	}
+	path = filepath.Clean(path)
+
+	log.Infof("User %s requesting history for %s", user.UID, path)
+
+	if !user.CanView(path) {
+		return echo.ErrUnauthorized
+	}
+
+	days := 30
+	if d := c.QueryParam("days"); len(d) > 0 {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid days")
+		}
+	}
+
+	samples, err := Conf().Cache().GetHistory(path, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Error("Failed to fetch quota history")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get quota history")
+	}
+
+	return c.JSON(http.StatusOK, samples)
+}
@@ -0,0 +1,320 @@
+// iquota web dashboard. Talks to the same api as the iquota client using the
+// session cookie set when the browser authenticated to load this page.
+(function () {
+  'use strict';
+
+  var WARN_PCT = 90;
+
+  var state = {
+    all: [],
+    sort: 'pct',
+    desc: true,
+    historyPath: null
+  };
+
+  function $(sel) {
+    return document.querySelector(sel);
+  }
+
+  function el(tag, attrs, children) {
+    var e = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === 'text') {
+        e.textContent = attrs[k];
+      } else if (k === 'class') {
+        e.className = attrs[k];
+      } else {
+        e.setAttribute(k, attrs[k]);
+      }
+    });
+    (children || []).forEach(function (c) {
+      e.appendChild(c);
+    });
+    return e;
+  }
+
+  // Same SI units as the iquota client (go-humanize)
+  function bytes(n) {
+    if (!n) {
+      return '0 B';
+    }
+    var units = ['B', 'kB', 'MB', 'GB', 'TB', 'PB', 'EB'];
+    var i = Math.min(Math.floor(Math.log(n) / Math.log(1000)), units.length - 1);
+    var v = n / Math.pow(1000, i);
+    return (v < 10 && i > 0 ? v.toFixed(1) : Math.round(v)) + ' ' + units[i];
+  }
+
+  function comma(n) {
+    return (n || 0).toLocaleString();
+  }
+
+  function pct(q) {
+    var limit = q.soft_limit || q.hard_limit;
+    return limit > 0 ? (q.used / limit) * 100 : 0;
+  }
+
+  function inGrace(q) {
+    return !!q.pretty_grace_period_expiration;
+  }
+
+  function showError(msg) {
+    var e = $('#error');
+    e.textContent = msg;
+    e.hidden = !msg;
+  }
+
+  function api(url) {
+    return fetch(url, { credentials: 'same-origin' }).then(function (res) {
+      if (res.status === 404) {
+        return [];
+      }
+      if (res.status === 429) {
+        throw new Error('Too many requests. Please try again shortly.');
+      }
+      if (!res.ok) {
+        throw new Error('Request failed: ' + res.status + ' ' + res.statusText);
+      }
+      return res.json();
+    });
+  }
+
+  function usageBar(q) {
+    var p = pct(q);
+    var cls = 'bar';
+    if (p >= 100) {
+      cls += ' over';
+    } else if (p >= WARN_PCT) {
+      cls += ' warn';
+    }
+    var fill = el('span');
+    fill.style.width = Math.min(p, 100) + '%';
+    return el('div', { class: 'usage' }, [
+      el('div', { class: cls, title: bytes(q.used) + ' of ' + bytes(q.soft_limit) }, [fill]),
+      el('span', { class: 'pct', text: p.toFixed(0) + '%' })
+    ]);
+  }
+
+  function graceCell(q) {
+    if (inGrace(q)) {
+      return el('td', { class: 'grace', text: 'expires ' + q.pretty_grace_period_expiration });
+    }
+    return el('td', { text: q.pretty_grace_period || '' });
+  }
+
+  function quotaRow(q) {
+    var hist = el('button', { class: 'link', text: 'history' });
+    hist.addEventListener('click', function () {
+      showHistory(q.path);
+    });
+
+    return el('tr', {}, [
+      el('td', { text: q.path }),
+      el('td', {}, [usageBar(q)]),
+      el('td', { class: 'num', text: bytes(q.used) }),
+      el('td', { class: 'num', text: q.soft_limit ? bytes(q.soft_limit) : '' }),
+      el('td', { class: 'num', text: comma(q.used_inodes) }),
+      graceCell(q),
+      el('td', {}, [hist])
+    ]);
+  }
+
+  function renderMine(quotas) {
+    var tbody = $('#mine tbody');
+    tbody.innerHTML = '';
+    quotas.forEach(function (q) {
+      tbody.appendChild(quotaRow(q));
+    });
+    $('#empty').hidden = quotas.length > 0;
+  }
+
+  function sortValue(q, key) {
+    switch (key) {
+      case 'pct':
+        return pct(q);
+      case 'grace':
+        return inGrace(q) ? 1 : 0;
+      case 'path':
+        return q.path;
+      default:
+        return q[key] || 0;
+    }
+  }
+
+  function renderAll() {
+    var text = $('#filter').value.toLowerCase();
+    var minPct = parseFloat($('#min-pct').value) || 0;
+    var graceOnly = $('#over-grace').checked;
+
+    var rows = state.all.filter(function (q) {
+      return q.path.toLowerCase().indexOf(text) !== -1 &&
+        pct(q) >= minPct &&
+        (!graceOnly || inGrace(q));
+    });
+
+    rows.sort(function (a, b) {
+      var x = sortValue(a, state.sort);
+      var y = sortValue(b, state.sort);
+      var c = x < y ? -1 : x > y ? 1 : 0;
+      return state.desc ? -c : c;
+    });
+
+    var tbody = $('#all tbody');
+    tbody.innerHTML = '';
+    rows.forEach(function (q) {
+      tbody.appendChild(quotaRow(q));
+    });
+
+    $('#count').textContent = rows.length + ' of ' + state.all.length + ' quotas';
+
+    document.querySelectorAll('#all th[data-sort]').forEach(function (th) {
+      th.classList.remove('asc', 'desc');
+      if (th.dataset.sort === state.sort) {
+        th.classList.add(state.desc ? 'desc' : 'asc');
+      }
+    });
+  }
+
+  function loadAll() {
+    if (state.all.length) {
+      renderAll();
+      return;
+    }
+    api('../export').then(function (quotas) {
+      state.all = quotas || [];
+      renderAll();
+    }).catch(function (err) {
+      showError(err.message);
+    });
+  }
+
+  // Simple SVG line chart of usage with the soft limit
+  function drawChart(samples) {
+    var chart = $('#chart');
+    chart.innerHTML = '';
+
+    if (!samples.length) {
+      chart.textContent = 'No history recorded yet.';
+      return;
+    }
+
+    var W = 900, H = 260, L = 70, R = 10, T = 10, B = 30;
+    var t0 = new Date(samples[0].time).getTime();
+    var t1 = new Date(samples[samples.length - 1].time).getTime();
+    var max = 0;
+    samples.forEach(function (s) {
+      max = Math.max(max, s.used, s.soft_limit);
+    });
+    max = max || 1;
+
+    function x(s) {
+      var t = new Date(s.time).getTime();
+      return L + (t1 > t0 ? (t - t0) / (t1 - t0) : 0.5) * (W - L - R);
+    }
+
+    function y(v) {
+      return T + (1 - v / max) * (H - T - B);
+    }
+
+    function line(key) {
+      return samples.map(function (s, i) {
+        return (i ? 'L' : 'M') + x(s).toFixed(1) + ',' + y(s[key]).toFixed(1);
+      }).join(' ');
+    }
+
+    var NS = 'http://www.w3.org/2000/svg';
+    var svg = document.createElementNS(NS, 'svg');
+    svg.setAttribute('viewBox', '0 0 ' + W + ' ' + H);
+
+    function add(tag, attrs, text) {
+      var e = document.createElementNS(NS, tag);
+      Object.keys(attrs).forEach(function (k) {
+        e.setAttribute(k, attrs[k]);
+      });
+      if (text) {
+        e.textContent = text;
+      }
+      svg.appendChild(e);
+    }
+
+    [0, 0.25, 0.5, 0.75, 1].forEach(function (f) {
+      var v = max * f;
+      add('line', { class: 'axis', x1: L, x2: W - R, y1: y(v), y2: y(v) });
+      add('text', { x: L - 6, y: y(v) + 4, 'text-anchor': 'end' }, bytes(v));
+    });
+
+    add('text', { x: L, y: H - 8 }, new Date(t0).toLocaleDateString());
+    add('text', { x: W - R, y: H - 8, 'text-anchor': 'end' }, new Date(t1).toLocaleDateString());
+    add('path', { class: 'limit', d: line('soft_limit') });
+    add('path', { class: 'used', d: line('used') });
+
+    chart.appendChild(svg);
+  }
+
+  function showHistory(path) {
+    state.historyPath = path;
+    $('#history').hidden = false;
+    $('#history-path').textContent = path;
+
+    var days = $('#history-days').value;
+    api('../history?path=' + encodeURIComponent(path) + '&days=' + days).then(function (samples) {
+      drawChart(samples || []);
+      $('#history').scrollIntoView({ behavior: 'smooth' });
+    }).catch(function (err) {
+      showError(err.message);
+    });
+  }
+
+  function selectTab(name) {
+    document.querySelectorAll('#tabs button').forEach(function (b) {
+      b.classList.toggle('active', b.dataset.tab === name);
+    });
+    $('#mine').hidden = name !== 'mine';
+    $('#all').hidden = name !== 'all';
+    if (name === 'all') {
+      loadAll();
+    }
+  }
+
+  function init() {
+    api('../me').then(function (me) {
+      $('#user').textContent = me.uid;
+      renderMine(me.quotas || []);
+
+      if (me.admin) {
+        $('#tabs').hidden = false;
+      }
+    }).catch(function (err) {
+      showError(err.message);
+    });
+
+    document.querySelectorAll('#tabs button').forEach(function (b) {
+      b.addEventListener('click', function () {
+        selectTab(b.dataset.tab);
+      });
+    });
+
+    document.querySelectorAll('#all th[data-sort]').forEach(function (th) {
+      th.addEventListener('click', function () {
+        if (state.sort === th.dataset.sort) {
+          state.desc = !state.desc;
+        } else {
+          state.sort = th.dataset.sort;
+          state.desc = th.dataset.sort !== 'path';
+        }
+        renderAll();
+      });
+    });
+
+    ['#filter', '#min-pct', '#over-grace'].forEach(function (sel) {
+      $(sel).addEventListener('input', renderAll);
+    });
+
+    $('#history-days').addEventListener('change', function () {
+      if (state.historyPath) {
+        showHistory(state.historyPath);
+      }
+    });
+  }
+
+  init();
+})();
@@ -0,0 +1,162 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+	"github.com/urfave/cli"
+)
+
+// Storage usage record in the XDMoD storage JSON log format
+type XDMoDUsage struct {
+	Resource      string `json:"resource"`
+	Mountpoint    string `json:"mountpoint"`
+	User          string `json:"user"`
+	PI            string `json:"pi"`
+	DateTime      string `json:"dt"`
+	SoftThreshold int    `json:"soft_threshold"`
+	HardThreshold int    `json:"hard_threshold"`
+	FileCount     int    `json:"file_count"`
+	LogicalUsage  int    `json:"logical_usage"`
+	PhysicalUsage int    `json:"physical_usage"`
+}
+
+// Maps the source of a quota to an XDMoD resource name using
+// xdmod.resources. Quotas from unmapped sources use xdmod.default_resource.
+func xdmodResource(source string) string {
+	resources := viper.GetStringMapString("xdmod.resources")
+	if r, ok := resources[source]; ok {
+		return r
+	}
+
+	return viper.GetString("xdmod.default_resource")
+}
+
+// Returns the name of the primary group of uid or uid if it can't be found
+func primaryGroup(uid string) string {
+	u, err := user.Lookup(uid)
+	if err != nil {
+		return uid
+	}
+
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		return uid
+	}
+
+	return g.Name
+}
+
+// BuildXDMoD returns the XDMoD storage usage records for quotas collected at
+// t. Home directories are reported for their user with the user's primary
+// group as PI. Group directories use the group as PI and the first group
+// manager, or the group name if none are configured, as user. Quotas from
+// sources with no XDMoD resource are skipped.
+func BuildXDMoD(quotas []*iquota.Quota, t time.Time) []*XDMoDUsage {
+	dt := t.UTC().Format(time.RFC3339)
+	usage := make([]*XDMoDUsage, 0, len(quotas))
+
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Path < quotas[j].Path })
+
+	for _, q := range quotas {
+		resource := xdmodResource(q.Source)
+		if len(resource) == 0 {
+			log.WithFields(log.Fields{
+				"path":   q.Path,
+				"source": q.Source,
+			}).Warn("No XDMoD resource for quota source, skipping")
+			continue
+		}
+
+		owner := PathOwner(q.Path)
+		if owner == nil {
+			log.WithFields(log.Fields{
+				"path": q.Path,
+			}).Warn("Quota is not a home or group directory, skipping")
+			continue
+		}
+
+		u := &XDMoDUsage{
+			Resource:      resource,
+			Mountpoint:    filepath.Dir(q.Path),
+			User:          owner.Name,
+			PI:            owner.Name,
+			DateTime:      dt,
+			SoftThreshold: q.SoftLimit,
+			HardThreshold: q.HardLimit,
+			FileCount:     q.UsedInodes,
+			LogicalUsage:  q.Used,
+			// Collectors only report one usage value
+			PhysicalUsage: q.Used,
+		}
+
+		if owner.Group {
+			if managers := Conf().GroupManagers[owner.Name]; len(managers) > 0 {
+				u.User = managers[0]
+			}
+		} else {
+			u.PI = primaryGroup(owner.Name)
+		}
+
+		usage = append(usage, u)
+	}
+
+	return usage
+}
+
+// WriteXDMoD writes the XDMoD storage usage log for all cached quotas to a
+// file named after today's date in dir
+func WriteXDMoD(dir string) (string, error) {
+	quotas, err := Conf().Cache().SearchDirectoryQuotaCache("")
+	if err != nil && !errors.Is(err, iquota.ErrNotFound) {
+		return "", err
+	}
+
+	now := time.Now()
+	out, err := json.MarshalIndent(BuildXDMoD(quotas, now), "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, now.Format("2006-01-02")+".json")
+	return file, writeFileAtomic(file, out)
+}
+
+func xdmodCommand() cli.Command {
+	return cli.Command{
+		Name:  "xdmod",
+		Usage: "Write a dated XDMoD storage usage log",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "dir", Usage: "Directory to write the log file"},
+		},
+		Action: func(c *cli.Context) error {
+			dir := c.String("dir")
+			if len(dir) == 0 {
+				dir = viper.GetString("xdmod.dir")
+			}
+			if len(dir) == 0 {
+				return errors.New("Please provide an output directory with --dir or set xdmod.dir")
+			}
+
+			file, err := WriteXDMoD(dir)
+			if err != nil {
+				return fmt.Errorf("Failed to write XDMoD storage log - %s", err)
+			}
+
+			log.Infof("Wrote XDMoD storage log %s", file)
+			return nil
+		},
+	}
+}
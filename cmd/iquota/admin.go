@@ -0,0 +1,152 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ubccr/iquota"
+	"github.com/urfave/cli"
+)
+
+func setQuotaCommand() cli.Command {
+	return cli.Command{
+		Name:  "set-quota",
+		Usage: "Create or update a directory quota (super-user only)",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "p,path", Usage: "Path to directory"},
+			&cli.StringFlag{Name: "limit", Usage: "Soft quota limit (ex. 2TB)"},
+			&cli.StringFlag{Name: "hard-limit", Usage: "Hard quota limit (default set by storage backend)"},
+			&cli.StringFlag{Name: "files", Usage: "Soft file limit (ex. 10M, default keep current limit)"},
+			&cli.StringFlag{Name: "hard-files", Usage: "Hard file limit (default set by storage backend)"},
+			&cli.BoolFlag{Name: "dry-run,n", Usage: "Show what would change without changing anything"},
+		},
+		Action: func(c *cli.Context) error {
+			limits := &iquota.Limits{}
+
+			if len(c.String("limit")) == 0 {
+				return errors.New("Please provide the quota limit (--limit)")
+			}
+
+			for _, l := range []struct {
+				flag  string
+				value *int
+			}{
+				{"limit", &limits.SoftLimit},
+				{"hard-limit", &limits.HardLimit},
+				{"files", &limits.SoftLimitInodes},
+				{"hard-files", &limits.HardLimitInodes},
+			} {
+				v := c.String(l.flag)
+				if len(v) == 0 {
+					continue
+				}
+
+				n, err := humanize.ParseBigBytes(v)
+				if err != nil || !n.IsInt64() {
+					return fmt.Errorf("Invalid --%s value: %s", l.flag, v)
+				}
+				*l.value = int(n.Int64())
+			}
+
+			return newQuotaClient().setQuota(c.String("path"), limits, c.Bool("dry-run"))
+		},
+	}
+}
+
+func deleteQuotaCommand() cli.Command {
+	return cli.Command{
+		Name:  "delete-quota",
+		Usage: "Delete a directory quota (super-user only)",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "p,path", Usage: "Path to directory"},
+			&cli.BoolFlag{Name: "dry-run,n", Usage: "Show what would change without changing anything"},
+		},
+		Action: func(c *cli.Context) error {
+			return newQuotaClient().deleteQuota(c.String("path"), c.Bool("dry-run"))
+		},
+	}
+}
+
+// Returns the path to send to the iquota server for a directory given on the
+// command line
+func (c *QuotaClient) serverPath(path string) (string, error) {
+	if len(path) == 0 {
+		return "", errors.New("Please provide a directory path (--path)")
+	}
+
+	_, remote, err := c.mounts.Resolve(path)
+	return remote, err
+}
+
+func printQuotaChange(change *iquota.QuotaChange) {
+	prefix := ""
+	if change.DryRun {
+		prefix = "[dry-run] would "
+	}
+
+	fmt.Printf("%s%s quota on %s (%s)\n", prefix, change.Action, change.Path, change.Backend)
+
+	if change.Current != nil {
+		fmt.Printf("  current: soft %s hard %s files %s/%s\n",
+			humanize.Bytes(uint64(change.Current.SoftLimit)),
+			humanize.Bytes(uint64(change.Current.HardLimit)),
+			humanize.Comma(int64(change.Current.SoftLimitInodes)),
+			humanize.Comma(int64(change.Current.HardLimitInodes)))
+	}
+
+	if change.Limits != nil {
+		fmt.Printf("  new:     soft %s hard %s files %s/%s\n",
+			humanize.Bytes(uint64(change.Limits.SoftLimit)),
+			limitString(change.Limits.HardLimit, true),
+			limitString(change.Limits.SoftLimitInodes, false),
+			limitString(change.Limits.HardLimitInodes, false))
+	}
+}
+
+// Format a limit where zero means the storage backend picks the value
+func limitString(v int, bytes bool) string {
+	if v == 0 {
+		return "default"
+	}
+
+	if bytes {
+		return humanize.Bytes(uint64(v))
+	}
+
+	return humanize.Comma(int64(v))
+}
+
+func (c *QuotaClient) setQuota(path string, limits *iquota.Limits, dryRun bool) error {
+	path, err := c.serverPath(path)
+	if err != nil {
+		return err
+	}
+
+	change, err := c.api.SetQuota(path, limits, dryRun)
+	if err != nil {
+		return requestError(err)
+	}
+
+	printQuotaChange(change)
+	return nil
+}
+
+func (c *QuotaClient) deleteQuota(path string, dryRun bool) error {
+	path, err := c.serverPath(path)
+	if err != nil {
+		return err
+	}
+
+	change, err := c.api.DeleteQuota(path, dryRun)
+	if err != nil {
+		return requestError(err)
+	}
+
+	printQuotaChange(change)
+	return nil
+}
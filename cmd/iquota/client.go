@@ -5,178 +5,358 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"strings"
+	"text/template"
+	"time"
 
-	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 	"github.com/ubccr/iquota"
-	"github.com/ubccr/kerby/khttp"
+	"github.com/ubccr/iquota/client"
 )
 
+// Max time to wait before reconnecting to the event stream
+const MaxFollowWait = time.Minute
+
 const (
-	QuotaEndpoint = "/quota"
-	LongFormat    = "%-30s%15s%15s%15s%10s%10s%12s\n"
-	ShortFormat   = "%-30s%15s%15s%15s%12s\n"
+	LongFormat  = "%-30s%15s%15s%15s%10s%10s%7s%7s%12s\n"
+	ShortFormat = "%-30s%15s%15s%15s%7s%7s%12s\n"
+	OverFormat  = "%-30s%15s%15s%10s%10s%8s%8s%22s\n"
 )
 
 var (
-	cyan   = color.New(color.FgCyan)
-	green  = color.New(color.FgGreen)
-	red    = color.New(color.FgRed)
-	yellow = color.New(color.FgYellow)
-	blue   = color.New(color.FgBlue)
+	cyan    = color.New(color.FgCyan)
+	green   = color.New(color.FgGreen)
+	red     = color.New(color.FgRed)
+	yellow  = color.New(color.FgYellow)
+	blue    = color.New(color.FgBlue)
+	magenta = color.New(color.FgMagenta)
+	boldRed = color.New(color.FgRed, color.Bold)
 )
 
 type QuotaClient struct {
-	Group       bool
-	User        bool
-	Long        bool
-	UserFilter  string
-	GroupFilter string
-	Path        string
-	certPool    *x509.CertPool
+	Group           bool
+	User            bool
+	Long            bool
+	UserFilter      string
+	GroupFilter     string
+	Path            string
+	Dir             string
+	Over            bool
+	OverPercent     float64
+	OverLimit       string
+	OverType        string
+	Follow          bool
+	Output          string
+	Template        string
+	Bytes           bool
+	NearPercent     float64
+	NearHardPercent float64
+	Bar             bool
+	BarWidth        int
+	api             *client.Client
+	tmpl            *template.Template
+	mounts          *MountMap
+
+	// Mount containing Dir
+	mount *Mount
 }
 
+// Row format with a trailing column for the usage bar if enabled
 func (c *QuotaClient) format() string {
+	format := ShortFormat
 	if c.Long {
-		return LongFormat
-	}
-
-	return ShortFormat
-}
-
-func (c *QuotaClient) fetchQuota(url string) ([]*iquota.Quota, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: c.certPool}}
-
-	// XXX should we default to this? seems a bit rash? Perhaps make this a config option
-	if c.certPool == nil {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
-
-	t := &khttp.Transport{Next: tr}
-	client := &http.Client{Transport: t}
-
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
+		format = LongFormat
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusInternalServerError {
-		return nil, fmt.Errorf("Failed to fetch quota with HTTP status code: %d", res.StatusCode)
-	} else if res.StatusCode == 404 {
-		return nil, iquota.ErrNotFound
-	} else if res.StatusCode == 401 {
-		return nil, fmt.Errorf("You are not authorized to fetch this quota")
-	} else if res.StatusCode != 200 {
-		return nil, fmt.Errorf("Failed to fetch quota with HTTP status code: %d", res.StatusCode)
+	if c.Bar {
+		return strings.TrimSuffix(format, "\n") + "  %s\n"
 	}
 
-	rawJson, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
+	return format
+}
 
-	var quotas []*iquota.Quota
-	err = json.Unmarshal(rawJson, &quotas)
-	if err != nil {
-		return nil, err
+// Print a row adding the usage bar of quota if enabled
+func (c *QuotaClient) printRow(printer *color.Color, quota *iquota.Quota, cols ...interface{}) {
+	if c.Bar {
+		bar := "usage"
+		if quota != nil {
+			bar = usageBar(quota, c.BarWidth)
+		}
+		cols = append(cols, bar)
 	}
 
-	return quotas, nil
+	printer.Printf(c.format(), cols...)
 }
 
 func (c *QuotaClient) printHeader() {
 	if c.Long {
-		fmt.Printf(c.format(), "Path ", "files", "limit", "used", "soft", "hard", "grace ")
+		c.printRow(blue, nil, "Path ", "files", "limit", "used", "soft", "hard", "%soft", "%hard", "grace ")
 		return
 	}
 
-	fmt.Printf(c.format(), "Path ", "files", "used", "limit", "grace ")
+	c.printRow(blue, nil, "Path ", "files", "used", "limit", "%soft", "%hard", "grace ")
 }
 
+// Print quota colored by the severity of its usage
 func (c *QuotaClient) printQuota(quota *iquota.Quota) {
-	printer := cyan
 	soft := ""
 	hard := ""
 	if quota.SoftLimit > 0 {
-		soft = humanize.Bytes(uint64(quota.SoftLimit))
+		soft = c.bytes(quota.SoftLimit)
 	}
 	if quota.HardLimit > 0 {
-		hard = humanize.Bytes(uint64(quota.HardLimit))
+		hard = c.bytes(quota.HardLimit)
 	}
 
+	printer := c.printer(quota)
+	path := c.mounts.Local(quota.Path)
 	if c.Long {
-		printer.Printf(c.format(),
-			quota.Path,
-			humanize.Comma(int64(quota.UsedInodes)),
-			humanize.Comma(int64(quota.HardLimitInodes)),
-			humanize.Bytes(uint64(quota.Used)),
+		c.printRow(printer, quota,
+			path,
+			c.count(quota.UsedInodes),
+			c.count(quota.HardLimitInodes),
+			c.bytes(quota.Used),
 			soft,
 			hard,
+			percentString(quota, iquota.LimitSoft),
+			percentString(quota, iquota.LimitHard),
 			quota.GracePeriod)
 
 		return
 	}
 
-	printer.Printf(c.format(),
-		quota.Path,
-		humanize.Comma(int64(quota.UsedInodes)),
-		humanize.Bytes(uint64(quota.Used)),
+	c.printRow(printer, quota,
+		path,
+		c.count(quota.UsedInodes),
+		c.bytes(quota.Used),
 		soft,
+		percentString(quota, iquota.LimitSoft),
+		percentString(quota, iquota.LimitHard),
 		quota.GracePeriod)
 }
 
-func (c *QuotaClient) printDirectoryQuota() {
-	c.printHeader()
-	params := url.Values{}
-	if len(c.Path) > 0 {
-		params.Add("path", c.Path)
-	} else if len(c.UserFilter) > 0 {
-		params.Add("user", c.UserFilter)
-	} else if len(c.GroupFilter) > 0 {
-		params.Add("group", c.GroupFilter)
+// Query for the quotas selected on the command line
+func (c *QuotaClient) query() client.QuotaQuery {
+	query := client.QuotaQuery{
+		User:  c.UserFilter,
+		Group: c.GroupFilter,
+	}
+
+	if len(c.Dir) > 0 {
+		local, remote, err := c.mounts.Resolve(c.Dir)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		query.Path = remote
+		query.Parents = true
+		c.mount, _ = c.mounts.Mount(local)
+	} else if len(c.Path) > 0 {
+		path, err := c.serverPath(c.Path)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		query.Path = path
+	}
+
+	return query
+}
+
+// Drop the quotas of parent directories outside the mount containing Dir.
+// Quota roots never span filesystems.
+func (c *QuotaClient) onMount(quotas []*iquota.Quota) []*iquota.Quota {
+	if c.mount == nil {
+		return quotas
+	}
+
+	filtered := make([]*iquota.Quota, 0, len(quotas))
+	for _, q := range quotas {
+		if _, ok := iquota.TrimPathPrefix(c.mounts.Local(q.Path), c.mount.MountPoint); ok {
+			filtered = append(filtered, q)
+		}
 	}
 
-	apiUrl := fmt.Sprintf("%s%s?%s", viper.GetString("iquota_url"), QuotaEndpoint, params.Encode())
+	return filtered
+}
 
-	quotas, err := c.fetchQuota(apiUrl)
+// Print the quotas selected on the command line. Returns the quotas printed
+func (c *QuotaClient) printDirectoryQuota() []*iquota.Quota {
+	if !c.machine() {
+		c.printHeader()
+	}
+	quotas, err := c.api.Quota(c.query())
 	if err != nil {
 		if errors.Is(err, iquota.ErrNotFound) {
 			logrus.Warn("No quotas found")
-			return
+			return nil
 		}
 
-		if strings.Contains(err.Error(), "No Kerberos credentials available") {
-			logrus.Fatal("No Kerberos credentials available. Please run kinit")
+		logrus.Fatal(requestError(err))
+		return nil
+	}
+
+	quotas = c.onMount(quotas)
+	if len(quotas) == 0 {
+		logrus.Warn("No quotas found")
+		return nil
+	}
+
+	if c.machine() {
+		if err := c.writeQuotas(quotas); err != nil {
+			logrus.Fatal(err)
+		}
+		return quotas
+	}
+
+	for _, quota := range quotas {
+		c.printQuota(quota)
+	}
+
+	return quotas
+}
+
+func (c *QuotaClient) printOverQuota() {
+	quotas, err := c.api.Over(client.OverQuery{
+		Percent: c.OverPercent,
+		Limit:   c.OverLimit,
+		Type:    c.OverType,
+	})
+	if err != nil {
+		if errors.Is(err, iquota.ErrNotFound) {
+			logrus.Warn("No quotas found")
 			return
 		}
 
-		logrus.Fatal(err)
+		logrus.Fatal(requestError(err))
 		return
 	}
 
-	if len(quotas) == 0 {
-		logrus.Warn("No quotas found")
+	if c.machine() {
+		if err := c.writeOverQuotas(quotas); err != nil {
+			logrus.Fatal(err)
+		}
 		return
 	}
 
-	for _, quota := range quotas {
-		c.printQuota(quota)
+	blue.Printf(OverFormat, "Path ", "files", "used", "soft", "hard", "%soft", "%hard", "grace expires ")
+	for _, q := range quotas {
+		soft := ""
+		hard := ""
+		if q.SoftLimit > 0 {
+			soft = c.bytes(q.SoftLimit)
+		}
+		if q.HardLimit > 0 {
+			hard = c.bytes(q.HardLimit)
+		}
+
+		c.printer(q.Quota).Printf(OverFormat,
+			c.mounts.Local(q.Path),
+			c.count(q.UsedInodes),
+			c.bytes(q.Used),
+			soft,
+			hard,
+			fmt.Sprintf("%.0f%%", q.PercentSoft),
+			fmt.Sprintf("%.0f%%", q.PercentHard),
+			q.GraceExpiration)
+	}
+}
+
+// Describes the threshold levels of quota events
+var levelDescriptions = map[string]string{
+	iquota.LevelOK:   "under quota",
+	iquota.LevelWarn: "near soft limit",
+	iquota.LevelSoft: "over soft limit",
+	iquota.LevelHard: "over hard limit",
+}
+
+func (c *QuotaClient) printEvent(event *iquota.QuotaEvent) {
+	if event.Type != iquota.EventThreshold {
+		c.printQuota(event.Quota)
+		return
+	}
+
+	printer := red
+	if event.Level == iquota.LevelOK {
+		printer = green
+	} else if event.Level == iquota.LevelWarn {
+		printer = yellow
+	}
+
+	printer.Printf("%s %s is %s (was %s)\n",
+		event.Time.Format("2006-01-02 15:04:05"),
+		c.mounts.Local(event.Quota.Path),
+		levelDescriptions[event.Level],
+		levelDescriptions[event.PreviousLevel])
+}
+
+// Returns true if following the event stream should be retried after err
+func retryFollow(err error) bool {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+
+	return !errors.Is(err, iquota.ErrNotFound) &&
+		!errors.Is(err, client.ErrUnauthorized) &&
+		!strings.Contains(err.Error(), "No Kerberos credentials available")
+}
+
+// Print quota changes as they happen until interrupted. Reconnects with
+// backoff if the stream is closed.
+func (c *QuotaClient) followQuota(query client.QuotaQuery) {
+	wait := time.Second
+	for {
+		err := c.api.Events(query, func(event *iquota.QuotaEvent) error {
+			wait = time.Second
+			if c.machine() {
+				return c.writeEvent(event)
+			}
+			c.printEvent(event)
+			return nil
+		})
+		if err != nil && !retryFollow(err) {
+			logrus.Fatal(requestError(err))
+		}
+
+		var rerr *client.RateLimitError
+		if errors.As(err, &rerr) && rerr.RetryAfter > wait {
+			wait = rerr.RetryAfter
+		}
+
+		logrus.Infof("Event stream closed (%v), reconnecting in %s", err, wait)
+		time.Sleep(wait)
+
+		wait *= 2
+		if wait > MaxFollowWait {
+			wait = MaxFollowWait
+		}
 	}
 }
 
 func (c *QuotaClient) Run() {
-	c.printDirectoryQuota()
+	if err := c.setupOutput(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if c.Over {
+		c.printOverQuota()
+		return
+	}
+
+	quotas := c.printDirectoryQuota()
+
+	if c.Follow {
+		query := c.query()
+		if len(c.Dir) > 0 {
+			// Follow the nearest quota root governing Dir
+			if len(quotas) == 0 {
+				return
+			}
+			query = client.QuotaQuery{Path: quotas[0].Path}
+		}
+
+		c.followQuota(query)
+	}
 }
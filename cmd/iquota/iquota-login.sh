@@ -0,0 +1,9 @@
+# Warn about quotas near or over their limits when logging in. Prints nothing
+# if all quotas are healthy. See login_check in /etc/iquota/iquota.yaml
+case $- in
+    *i*)
+        if [ -x /usr/bin/iquota ]; then
+            /usr/bin/iquota --login-check 2>/dev/null
+        fi
+        ;;
+esac
@@ -27,9 +27,11 @@ rm -rf %{buildroot}
 install -d %{buildroot}%{_datadir}/%{name}
 install -d %{buildroot}%{_sysconfdir}/%{name}
 install -d %{buildroot}%{_bindir}
+install -d %{buildroot}%{_sysconfdir}/profile.d
 
 cp -a ./%{name}.yaml.sample %{buildroot}%{_sysconfdir}/%{name}/%{name}.yaml
 cp -a ./%{name} %{buildroot}%{_bindir}/%{name}
+cp -a ./%{name}-login.sh %{buildroot}%{_sysconfdir}/profile.d/%{name}.sh
 
 %clean
 rm -rf %{buildroot}
@@ -40,6 +42,7 @@ rm -rf %{buildroot}
 %license LICENSE
 %attr(0755,root,root) %{_bindir}/%{name}
 %attr(644,root,root) %config(noreplace) %{_sysconfdir}/%{name}/%{name}.yaml
+%attr(644,root,root) %config(noreplace) %{_sysconfdir}/profile.d/%{name}.sh
 
 %changelog
 * Sun Jan 31 2021  Andrew E. Bruno <aebruno2@buffalo.edu> 0.0.6-1
@@ -0,0 +1,193 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ubccr/iquota"
+)
+
+const (
+	// Seconds to wait for the server during a login check
+	DefaultLoginTimeout = 2
+
+	// Seconds to reuse the result of the last login check
+	DefaultLoginCacheTTL = 3600
+
+	// Cached quotas older than this are not shown if the server can't be
+	// reached
+	MaxLoginCacheAge = 24 * time.Hour
+)
+
+// Result of the last login check, cached per user
+type loginCache struct {
+	// Time of the last attempt to fetch quotas, successful or not
+	Checked time.Time `json:"checked"`
+
+	// Time the quotas were fetched
+	Time   time.Time       `json:"time"`
+	Quotas []*iquota.Quota `json:"quotas"`
+}
+
+// Returns true if the last check is at least ttl old and the server should be
+// asked again
+func (lc *loginCache) stale(now time.Time, ttl time.Duration) bool {
+	return now.Sub(lc.Checked) >= ttl
+}
+
+// Returns true if the cached quotas are recent enough to warn about
+func (lc *loginCache) usable(now time.Time) bool {
+	return !lc.Time.IsZero() && now.Sub(lc.Time) <= MaxLoginCacheAge
+}
+
+// DefaultLoginCacheFile returns the path of the login check cache in the
+// user's cache directory
+func DefaultLoginCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "iquota", "login-check.json")
+}
+
+func readLoginCache(path string) *loginCache {
+	lc := &loginCache{}
+	if len(path) == 0 {
+		return lc
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lc
+	}
+
+	if err := json.Unmarshal(data, lc); err != nil {
+		logrus.Infof("Ignoring invalid login check cache %s: %s", path, err)
+		return &loginCache{}
+	}
+
+	return lc
+}
+
+func writeLoginCache(path string, lc *loginCache) error {
+	if len(path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(lc)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Fetch the user's quotas giving up after timeout. Kerberos and the request
+// run in the background so nothing can hold up the caller past the timeout.
+func (c *QuotaClient) fetchMyQuotas(timeout time.Duration) ([]*iquota.Quota, error) {
+	c.api.Timeout = timeout
+	c.api.MaxRetryWait = 0
+
+	type result struct {
+		quotas []*iquota.Quota
+		err    error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		d, err := c.api.Me()
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		resc <- result{quotas: d.Quotas}
+	}()
+
+	select {
+	case res := <-resc:
+		return res.quotas, res.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("Timed out after %s", timeout)
+	}
+}
+
+// Short description of a quota's severity for the login warning
+func (c *QuotaClient) loginWarning(q *iquota.Quota, severity string) string {
+	switch severity {
+	case SeverityNear:
+		// Name whichever limit usage is near
+		if c.NearPercent > 0 && maxPercent(q, iquota.LimitSoft) >= c.NearPercent {
+			return fmt.Sprintf("is nearly full (%s of soft limit used)", percentString(q, iquota.LimitSoft))
+		}
+		return fmt.Sprintf("is nearly full (%s of hard limit used)", percentString(q, iquota.LimitHard))
+	case SeverityOverSoft:
+		return fmt.Sprintf("is over its soft limit (%s used)", percentString(q, iquota.LimitSoft))
+	case SeverityInGrace:
+		return fmt.Sprintf("is over its soft limit, grace period expires %s", q.GraceExpiration)
+	case SeverityOverHard:
+		return "is over its hard limit, writes will fail"
+	}
+
+	return ""
+}
+
+// LoginCheck prints a short warning for each of the user's quotas that is
+// near or over its limit and nothing if all are healthy. Meant to be run at
+// login so results are cached per user for cache_ttl seconds, the server is
+// given at most timeout seconds and errors are never printed.
+func (c *QuotaClient) LoginCheck(cacheFile string, timeout, ttl time.Duration) {
+	lc := readLoginCache(cacheFile)
+
+	if lc.stale(time.Now(), ttl) {
+		lc.Checked = time.Now()
+		quotas, err := c.fetchMyQuotas(timeout)
+		if err != nil {
+			logrus.Infof("Failed to fetch quotas for login check: %s", err)
+		} else {
+			lc.Time = lc.Checked
+			lc.Quotas = quotas
+		}
+
+		if err := writeLoginCache(cacheFile, lc); err != nil {
+			logrus.Infof("Failed to write login check cache: %s", err)
+		}
+	}
+
+	if !lc.usable(time.Now()) {
+		return
+	}
+
+	warned := false
+	for _, q := range lc.Quotas {
+		severity := c.severity(q)
+		msg := c.loginWarning(q, severity)
+		if len(msg) == 0 {
+			continue
+		}
+
+		severityPrinters[severity].Printf("Quota warning: %s %s\n", c.mounts.Local(q.Path), msg)
+		warned = true
+	}
+
+	if warned {
+		fmt.Println("Run iquota for details.")
+	}
+}
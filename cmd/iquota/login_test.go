@@ -0,0 +1,102 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubccr/iquota"
+)
+
+func TestLoginCacheAge(t *testing.T) {
+	now := time.Now()
+	ttl := time.Hour
+
+	tests := []struct {
+		checked time.Time
+		fetched time.Time
+		stale   bool
+		usable  bool
+	}{
+		// Never checked
+		{time.Time{}, time.Time{}, true, false},
+		{now.Add(-time.Minute), now.Add(-time.Minute), false, true},
+		{now.Add(-ttl), now.Add(-ttl), true, true},
+		// Last check failed but earlier quotas are still recent enough
+		{now.Add(-time.Minute), now.Add(-12 * time.Hour), false, true},
+		{now.Add(-time.Minute), now.Add(-MaxLoginCacheAge - time.Minute), false, false},
+		{now.Add(-2 * ttl), now.Add(-MaxLoginCacheAge), true, true},
+	}
+
+	for i, test := range tests {
+		lc := &loginCache{Checked: test.checked, Time: test.fetched}
+		if got := lc.stale(now, ttl); got != test.stale {
+			t.Errorf("%d: stale = %t, want %t", i, got, test.stale)
+		}
+		if got := lc.usable(now); got != test.usable {
+			t.Errorf("%d: usable = %t, want %t", i, got, test.usable)
+		}
+	}
+}
+
+func TestLoginCacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iquota", "login-check.json")
+
+	// Missing files are an empty cache
+	if lc := readLoginCache(path); !lc.stale(time.Now(), time.Hour) {
+		t.Error("Missing cache file should be stale")
+	}
+
+	now := time.Now().Round(time.Second)
+	err := writeLoginCache(path, &loginCache{
+		Checked: now,
+		Time:    now,
+		Quotas:  []*iquota.Quota{{Path: "/home/alice", Used: 10, SoftLimit: 100}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc := readLoginCache(path)
+	if !lc.Checked.Equal(now) || !lc.Time.Equal(now) || len(lc.Quotas) != 1 || lc.Quotas[0].Path != "/home/alice" {
+		t.Errorf("Cache not read back: %+v", lc)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if lc := readLoginCache(path); !lc.Checked.IsZero() || lc.Quotas != nil {
+		t.Errorf("Invalid cache file not ignored: %+v", lc)
+	}
+
+	// No cache file disables caching
+	if err := writeLoginCache("", &loginCache{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginWarningNear(t *testing.T) {
+	c := &QuotaClient{NearPercent: 90, NearHardPercent: 80}
+
+	tests := []struct {
+		quota iquota.Quota
+		want  string
+	}{
+		{iquota.Quota{Used: 95, SoftLimit: 100, HardLimit: 200}, "is nearly full (95% of soft limit used)"},
+		// Near the hard limit without a soft limit
+		{iquota.Quota{Used: 85, HardLimit: 100}, "is nearly full (85% of hard limit used)"},
+		// Near the hard limit but not the soft limit
+		{iquota.Quota{Used: 85, SoftLimit: 100, HardLimit: 100}, "is nearly full (85% of hard limit used)"},
+	}
+
+	for _, test := range tests {
+		if got := c.loginWarning(&test.quota, SeverityNear); got != test.want {
+			t.Errorf("loginWarning(%+v) = %q, want %q", test.quota, got, test.want)
+		}
+	}
+}
@@ -8,9 +8,12 @@ package main
 import (
 	"crypto/x509"
 	"io/ioutil"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/ubccr/iquota/client"
 	"github.com/urfave/cli"
 )
 
@@ -20,6 +23,38 @@ func init() {
 	viper.AddConfigPath("/etc/iquota/")
 
 	viper.SetDefault("iquota_url", "http://localhost")
+	viper.SetDefault("session_file", client.DefaultSessionFile())
+	viper.SetDefault("severity.near", 90)
+	viper.SetDefault("severity.near_hard", 90)
+	viper.SetDefault("severity.bar_width", DefaultBarWidth)
+	viper.SetDefault("login_check.timeout", DefaultLoginTimeout)
+	viper.SetDefault("login_check.cache_ttl", DefaultLoginCacheTTL)
+	viper.SetDefault("login_check.cache_file", DefaultLoginCacheFile())
+}
+
+// Create a new client using the server URL, CA cert and session file from the
+// config
+func newQuotaClient() *QuotaClient {
+	api := client.New(viper.GetString("iquota_url"))
+	api.Jar = client.NewSessionJar(viper.GetString("session_file"))
+
+	cert := viper.GetString("iquota_cert")
+	if len(cert) > 0 {
+		pem, err := ioutil.ReadFile(cert)
+		if err != nil {
+			logrus.Fatal("Failed reading cacert file: ", err)
+		}
+
+		api.RootCAs = x509.NewCertPool()
+		if !api.RootCAs.AppendCertsFromPEM(pem) {
+			logrus.Fatal("Failed appending cacert file to pool: ", err)
+		}
+	} else {
+		// XXX should we default to this? seems a bit rash? Perhaps make this a config option
+		api.InsecureSkipVerify = true
+	}
+
+	return &QuotaClient{api: api, mounts: loadMountMap()}
 }
 
 func main() {
@@ -27,6 +62,7 @@ func main() {
 	app.Name = "iquota"
 	app.Authors = []cli.Author{cli.Author{Name: "Andrew E. Bruno", Email: "aebruno2@buffalo.edu"}}
 	app.Usage = "displays CCR quotas"
+	app.ArgsUsage = "[directory]"
 	app.Version = "0.0.6"
 	app.HideVersion = true
 	app.Flags = []cli.Flag{
@@ -37,6 +73,18 @@ func main() {
 		&cli.StringFlag{Name: "show-user", Usage: "Print user quota for specified user (super-user only)"},
 		&cli.StringFlag{Name: "show-group", Usage: "Print group quota for specified group"},
 		&cli.StringFlag{Name: "p,path,f,filesystem", Usage: "report quota for filesystem path"},
+		&cli.BoolFlag{Name: "here", Usage: "Report the quotas governing the current directory (same as iquota .)"},
+		&cli.BoolFlag{Name: "follow", Usage: "Keep running and print quota changes as they happen"},
+		&cli.BoolFlag{Name: "login-check", Usage: "Only warn about quotas near or over their limit. For use in /etc/profile.d"},
+		&cli.BoolFlag{Name: "over", Usage: "Print all quotas over a percent of their limit (super-user only)"},
+		&cli.Float64Flag{Name: "over-percent", Usage: "Percent of limit used to report a quota with --over (default set by server)"},
+		&cli.StringFlag{Name: "over-limit", Usage: "Limit to compare usage against with --over: soft or hard", Value: "soft"},
+		&cli.StringFlag{Name: "over-type", Usage: "Usage to compare with --over: bytes or inodes (default both)"},
+		&cli.StringFlag{Name: "o,output", Usage: "Output format: text, json, csv, tsv or yaml", Value: OutputText},
+		&cli.StringFlag{Name: "template", Usage: "Print each quota with a Go template (ex. '{{.Path}} {{bytes .Used}}')"},
+		&cli.BoolFlag{Name: "bytes", Usage: "Print sizes in bytes and file counts without commas"},
+		&cli.BoolFlag{Name: "bar", Usage: "Show a bar of the percent of the limit used"},
+		&cli.Float64Flag{Name: "near-percent", Usage: "Percent of the soft limit used to highlight a quota as near its limit (default set in config)"},
 	}
 	app.Before = func(c *cli.Context) error {
 		if c.GlobalBool("debug") {
@@ -52,32 +100,56 @@ func main() {
 
 		viper.ReadInConfig()
 
+		// Only color output to a terminal
+		if !isTerminal() {
+			color.NoColor = true
+		}
+
 		return nil
 	}
 	app.Action = func(c *cli.Context) {
-		client := &QuotaClient{
-			Group:       c.Bool("group"),
-			User:        c.Bool("user"),
-			Long:        c.Bool("long"),
-			UserFilter:  c.String("show-user"),
-			GroupFilter: c.String("show-group"),
-			Path:        c.String("path"),
+		qc := newQuotaClient()
+		qc.Group = c.Bool("group")
+		qc.User = c.Bool("user")
+		qc.Long = c.Bool("long")
+		qc.UserFilter = c.String("show-user")
+		qc.GroupFilter = c.String("show-group")
+		qc.Path = c.String("path")
+		if c.Bool("here") {
+			qc.Dir = "."
+		} else if c.NArg() > 0 {
+			qc.Dir = c.Args().First()
+		}
+		qc.Follow = c.Bool("follow")
+		qc.Over = c.Bool("over")
+		qc.OverPercent = c.Float64("over-percent")
+		qc.OverLimit = c.String("over-limit")
+		qc.OverType = c.String("over-type")
+		qc.Output = c.String("output")
+		qc.Template = c.String("template")
+		qc.Bytes = c.Bool("bytes")
+		qc.Bar = c.Bool("bar")
+		qc.BarWidth = viper.GetInt("severity.bar_width")
+		qc.NearPercent = viper.GetFloat64("severity.near")
+		qc.NearHardPercent = viper.GetFloat64("severity.near_hard")
+		if c.IsSet("near-percent") {
+			qc.NearPercent = c.Float64("near-percent")
 		}
 
-		cert := viper.GetString("iquota_cert")
-		if len(cert) > 0 {
-			pem, err := ioutil.ReadFile(cert)
-			if err != nil {
-				logrus.Fatal("Failed reading cacert file: ", err)
-			}
-
-			client.certPool = x509.NewCertPool()
-			if !client.certPool.AppendCertsFromPEM(pem) {
-				logrus.Fatal("Failed appending cacert file to pool: ", err)
-			}
+		if c.Bool("login-check") {
+			qc.LoginCheck(viper.GetString("login_check.cache_file"),
+				time.Duration(viper.GetFloat64("login_check.timeout")*float64(time.Second)),
+				time.Duration(viper.GetInt("login_check.cache_ttl"))*time.Second)
+			return
 		}
 
-		client.Run()
+		qc.Run()
+	}
+	app.Commands = []cli.Command{
+		requestCommand(),
+		tempCommand(),
+		setQuotaCommand(),
+		deleteQuotaCommand(),
 	}
 
 	app.RunAndExitOnError()
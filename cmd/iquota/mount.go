@@ -0,0 +1,243 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+const MountInfoFile = "/proc/self/mountinfo"
+
+// Mount from /proc/self/mountinfo
+type Mount struct {
+	Root       string
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+// Entry in the mount_map config. Paths under the local mount point, or under
+// wherever Source is mounted on this machine, are sent to the server under
+// Path.
+type MountMapping struct {
+	Local  string `mapstructure:"local"`
+	Source string `mapstructure:"source"`
+	Path   string `mapstructure:"path"`
+}
+
+// Translates between local paths and the paths used by the iquota server
+type MountMap struct {
+	mounts []*Mount
+	pairs  []mountPair
+}
+
+type mountPair struct {
+	local  string
+	remote string
+}
+
+// Undo the octal escapes of spaces, tabs, newlines and backslashes in
+// mountinfo fields
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// Parse mounts in the format of /proc/self/mountinfo:
+//
+//	36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw
+func parseMountInfo(r io.Reader) ([]*Mount, error) {
+	mounts := make([]*Mount, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Optional fields end with a single hyphen
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep == -1 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("Invalid mountinfo line: %s", scanner.Text())
+		}
+
+		mounts = append(mounts, &Mount{
+			Root:       unescapeMountField(fields[3]),
+			MountPoint: unescapeMountField(fields[4]),
+			FSType:     fields[sep+1],
+			Source:     unescapeMountField(fields[sep+2]),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// NewMountMap builds the translation table from the mount_map config and the
+// given mounts
+func NewMountMap(mappings []*MountMapping, mounts []*Mount) (*MountMap, error) {
+	m := &MountMap{mounts: mounts}
+
+	for _, mapping := range mappings {
+		if !filepath.IsAbs(mapping.Path) {
+			return nil, fmt.Errorf("Invalid mount_map path must be an absolute path: %s", mapping.Path)
+		}
+		remote := filepath.Clean(mapping.Path)
+
+		switch {
+		case len(mapping.Local) > 0:
+			if !filepath.IsAbs(mapping.Local) {
+				return nil, fmt.Errorf("Invalid mount_map local must be an absolute path: %s", mapping.Local)
+			}
+			m.pairs = append(m.pairs, mountPair{local: filepath.Clean(mapping.Local), remote: remote})
+		case len(mapping.Source) > 0:
+			// Bind mounts of a subdirectory of the source have it as root
+			for _, mount := range mounts {
+				if mount.Source == mapping.Source {
+					m.pairs = append(m.pairs, mountPair{local: mount.MountPoint, remote: filepath.Join(remote, mount.Root)})
+				}
+			}
+		default:
+			return nil, fmt.Errorf("Missing local or source for mount_map path %s", mapping.Path)
+		}
+	}
+
+	return m, nil
+}
+
+// Load the mount_map config and the mounts of this process. Mounts are only
+// needed to resolve source mappings so failing to read them is not fatal.
+func loadMountMap() *MountMap {
+	var mappings []*MountMapping
+	err := viper.UnmarshalKey("mount_map", &mappings)
+	if err != nil {
+		logrus.Fatalf("Invalid mount_map: %s", err)
+	}
+
+	var mounts []*Mount
+	f, err := os.Open(MountInfoFile)
+	if err == nil {
+		mounts, err = parseMountInfo(f)
+		f.Close()
+	}
+	if err != nil {
+		logrus.Infof("Failed to read mounts: %s", err)
+	}
+
+	m, err := NewMountMap(mappings, mounts)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	return m
+}
+
+// Mount returns the mount containing path
+func (m *MountMap) Mount(path string) (*Mount, bool) {
+	var best *Mount
+	for _, mount := range m.mounts {
+		if _, ok := iquota.TrimPathPrefix(path, mount.MountPoint); !ok {
+			continue
+		}
+		// Later mounts on the same mount point hide earlier ones
+		if best == nil || len(mount.MountPoint) >= len(best.MountPoint) {
+			best = mount
+		}
+	}
+
+	return best, best != nil
+}
+
+// Translate path using the pair whose from prefix is the longest match
+func (m *MountMap) translate(path string, from, to func(mountPair) string) string {
+	pairs := make([]mountPair, len(m.pairs))
+	copy(pairs, m.pairs)
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return len(from(pairs[i])) > len(from(pairs[j]))
+	})
+
+	for _, p := range pairs {
+		if rest, ok := iquota.TrimPathPrefix(path, from(p)); ok {
+			return filepath.Join(to(p), rest)
+		}
+	}
+
+	return path
+}
+
+// Remote translates a local path to the path used by the iquota server
+func (m *MountMap) Remote(path string) string {
+	return m.translate(path,
+		func(p mountPair) string { return p.local },
+		func(p mountPair) string { return p.remote })
+}
+
+// Local translates a path from the iquota server to the local path
+func (m *MountMap) Local(path string) string {
+	return m.translate(path,
+		func(p mountPair) string { return p.remote },
+		func(p mountPair) string { return p.local })
+}
+
+// Resolve turns path into an absolute path with symlinks resolved. Paths that
+// don't exist on this machine are only made absolute. Returns the local path
+// and the path to send to the iquota server.
+func (m *MountMap) Resolve(path string) (string, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	local, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		logrus.Infof("Failed to resolve symlinks in %s: %s", abs, err)
+		local = abs
+	}
+
+	if mount, ok := m.Mount(local); ok {
+		logrus.WithFields(logrus.Fields{
+			"mount":  mount.MountPoint,
+			"fstype": mount.FSType,
+			"source": mount.Source,
+		}).Info("Found mount for path")
+	}
+
+	return local, m.Remote(local), nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMountInfo = `22 1 253:0 / / rw,relatime shared:1 - xfs /dev/mapper/root rw
+36 22 0:45 / /vast rw,relatime shared:2 master:1 - nfs vast01:/ rw,vers=3
+37 22 0:45 /projects /projects rw,relatime - nfs vast01:/ rw,vers=3
+38 22 0:46 / /mnt/my\040data rw,relatime - nfs4 nas01:/export/with\134slash rw
+39 22 0:47 /grp-a /scratch/grp-a rw shared:4 master:2 propagate_from:3 - panfs panfs://pan01/ rw
+`
+
+func TestUnescapeMountField(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/vast", "/vast"},
+		{`/mnt/my\040data`, "/mnt/my data"},
+		{`/a\011b\012c`, "/a\tb\nc"},
+		{`/with\134slash`, `/with\slash`},
+		// Not an octal escape
+		{`/bad\09x`, `/bad\09x`},
+		{`/short\04`, `/short\04`},
+		{`/trailing\`, `/trailing\`},
+	}
+
+	for _, test := range tests {
+		if got := unescapeMountField(test.in); got != test.want {
+			t.Errorf("unescapeMountField(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Mount{
+		{Root: "/", MountPoint: "/", FSType: "xfs", Source: "/dev/mapper/root"},
+		{Root: "/", MountPoint: "/vast", FSType: "nfs", Source: "vast01:/"},
+		{Root: "/projects", MountPoint: "/projects", FSType: "nfs", Source: "vast01:/"},
+		{Root: "/", MountPoint: "/mnt/my data", FSType: "nfs4", Source: `nas01:/export/with\slash`},
+		{Root: "/grp-a", MountPoint: "/scratch/grp-a", FSType: "panfs", Source: "panfs://pan01/"},
+	}
+
+	if len(mounts) != len(want) {
+		t.Fatalf("Expected %d mounts got %d", len(want), len(mounts))
+	}
+	for i := range want {
+		if *mounts[i] != want[i] {
+			t.Errorf("Mount %d = %+v, want %+v", i, *mounts[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"36 22 0:45 / /vast rw,relatime shared:2 nfs vast01:/ rw",
+		"36 22 0:45 / /vast rw - nfs",
+		"36 22 0:45 /",
+	} {
+		if _, err := parseMountInfo(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error parsing %q", bad)
+		}
+	}
+}
+
+func testMountMap(t *testing.T) *MountMap {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMountMap([]*MountMapping{
+		{Source: "vast01:/", Path: "/vast"},
+		{Local: "/mnt/my data/", Path: "/nas"},
+		{Source: "panfs://pan01/", Path: "/panasas"},
+		{Source: "missing:/", Path: "/missing"},
+	}, mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestNewMountMap(t *testing.T) {
+	m := testMountMap(t)
+
+	// Bind mounts of a subdirectory map to that subdirectory and sources
+	// that aren't mounted are skipped
+	want := []mountPair{
+		{local: "/vast", remote: "/vast"},
+		{local: "/projects", remote: "/vast/projects"},
+		{local: "/mnt/my data", remote: "/nas"},
+		{local: "/scratch/grp-a", remote: "/panasas/grp-a"},
+	}
+
+	if len(m.pairs) != len(want) {
+		t.Fatalf("Expected %d mappings got %d: %+v", len(want), len(m.pairs), m.pairs)
+	}
+	for i := range want {
+		if m.pairs[i] != want[i] {
+			t.Errorf("Mapping %d = %+v, want %+v", i, m.pairs[i], want[i])
+		}
+	}
+
+	for _, bad := range [][]*MountMapping{
+		{{Local: "/mnt", Path: "relative"}},
+		{{Local: "relative", Path: "/vast"}},
+		{{Path: "/vast"}},
+	} {
+		if _, err := NewMountMap(bad, nil); err == nil {
+			t.Errorf("Expected error for mapping %+v", *bad[0])
+		}
+	}
+}
+
+func TestMountMapTranslate(t *testing.T) {
+	m := testMountMap(t)
+
+	tests := []struct {
+		local  string
+		remote string
+	}{
+		{"/vast", "/vast"},
+		{"/vast/home/alice", "/vast/home/alice"},
+		{"/projects", "/vast/projects"},
+		{"/projects/grp-a/run", "/vast/projects/grp-a/run"},
+		{"/mnt/my data/alice", "/nas/alice"},
+		{"/scratch/grp-a/out", "/panasas/grp-a/out"},
+		// Unmapped paths are unchanged
+		{"/home/alice", "/home/alice"},
+		{"/projectsX", "/projectsX"},
+	}
+
+	for _, test := range tests {
+		if got := m.Remote(test.local); got != test.remote {
+			t.Errorf("Remote(%s) = %s, want %s", test.local, got, test.remote)
+		}
+		if got := m.Local(test.remote); got != test.local {
+			t.Errorf("Local(%s) = %s, want %s", test.remote, got, test.local)
+		}
+	}
+
+	mount, ok := m.Mount("/projects/grp-a")
+	if !ok || mount.MountPoint != "/projects" {
+		t.Errorf("Mount(/projects/grp-a) = %+v", mount)
+	}
+	mount, ok = m.Mount("/home/alice")
+	if !ok || mount.MountPoint != "/" {
+		t.Errorf("Mount(/home/alice) = %+v", mount)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ubccr/iquota"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputCSV  = "csv"
+	OutputTSV  = "tsv"
+	OutputYAML = "yaml"
+)
+
+// Columns of csv and tsv output
+var (
+	quotaColumns = []string{"path", "files", "soft_files", "hard_files", "used", "soft", "hard", "grace_period", "grace_expiration", "source"}
+	overColumns  = append(append([]string{}, quotaColumns...), "percent_soft", "percent_hard")
+)
+
+// Returns true if stdout is a terminal
+func isTerminal() bool {
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Check the output options and parse the template
+func (c *QuotaClient) setupOutput() error {
+	switch c.Output {
+	case OutputText, OutputJSON, OutputCSV, OutputTSV, OutputYAML:
+	default:
+		return fmt.Errorf("Invalid output format %q. Must be one of text, json, csv, tsv or yaml", c.Output)
+	}
+
+	if len(c.Template) == 0 {
+		return nil
+	}
+
+	tmpl, err := template.New("output").Funcs(template.FuncMap{
+		"bytes": c.bytes,
+		"comma": c.count,
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(c.Template)
+	if err != nil {
+		return fmt.Errorf("Invalid template: %s", err)
+	}
+
+	c.tmpl = tmpl
+	return nil
+}
+
+// Returns true if output is in a machine-readable format
+func (c *QuotaClient) machine() bool {
+	return c.tmpl != nil || c.Output != OutputText
+}
+
+// Format a number of bytes in human units or raw with --bytes
+func (c *QuotaClient) bytes(v int) string {
+	if c.Bytes {
+		return strconv.Itoa(v)
+	}
+
+	return humanize.Bytes(uint64(v))
+}
+
+// Format a file count with commas or raw with --bytes
+func (c *QuotaClient) count(v int) string {
+	if c.Bytes {
+		return strconv.Itoa(v)
+	}
+
+	return humanize.Comma(int64(v))
+}
+
+// Returns a copy of quota with the local path
+func (c *QuotaClient) localQuota(quota *iquota.Quota) *iquota.Quota {
+	q := *quota
+	q.Path = c.mounts.Local(q.Path)
+	return &q
+}
+
+func (c *QuotaClient) quotaRow(q *iquota.Quota) []string {
+	return []string{
+		q.Path,
+		c.count(q.UsedInodes),
+		c.count(q.SoftLimitInodes),
+		c.count(q.HardLimitInodes),
+		c.bytes(q.Used),
+		c.bytes(q.SoftLimit),
+		c.bytes(q.HardLimit),
+		q.GracePeriod,
+		q.GraceExpiration,
+		q.Source,
+	}
+}
+
+func writeYAML(w io.Writer, v interface{}) error {
+	// Round trip through json to use the json field names in their order
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if len(b) > 0 && b[0] == '[' {
+		var items []yaml.MapSlice
+		err = yaml.Unmarshal(b, &items)
+		doc = items
+	} else {
+		var item yaml.MapSlice
+		err = yaml.Unmarshal(b, &item)
+		doc = item
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+// Write items in the selected machine-readable format. Templates are executed
+// once per item. csv and tsv write rows under header.
+func (c *QuotaClient) writeOutput(items []interface{}, header []string, rows [][]string) error {
+	out := os.Stdout
+
+	if c.tmpl != nil {
+		for _, item := range items {
+			if err := c.tmpl.Execute(out, item); err != nil {
+				return err
+			}
+			fmt.Fprintln(out)
+		}
+		return nil
+	}
+
+	switch c.Output {
+	case OutputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	case OutputYAML:
+		return writeYAML(out, items)
+	case OutputCSV, OutputTSV:
+		w := csv.NewWriter(out)
+		if c.Output == OutputTSV {
+			w.Comma = '\t'
+		}
+		if header != nil {
+			w.Write(header)
+		}
+		return w.WriteAll(rows)
+	}
+
+	return nil
+}
+
+func (c *QuotaClient) writeQuotas(quotas []*iquota.Quota) error {
+	items := make([]interface{}, 0, len(quotas))
+	rows := make([][]string, 0, len(quotas))
+	for _, quota := range quotas {
+		q := c.localQuota(quota)
+		items = append(items, q)
+		rows = append(rows, c.quotaRow(q))
+	}
+
+	return c.writeOutput(items, quotaColumns, rows)
+}
+
+func (c *QuotaClient) writeOverQuotas(quotas []*iquota.OverQuota) error {
+	items := make([]interface{}, 0, len(quotas))
+	rows := make([][]string, 0, len(quotas))
+	for _, over := range quotas {
+		o := &iquota.OverQuota{Quota: c.localQuota(over.Quota), PercentSoft: over.PercentSoft, PercentHard: over.PercentHard}
+		items = append(items, o)
+		rows = append(rows, append(c.quotaRow(o.Quota),
+			strconv.FormatFloat(o.PercentSoft, 'f', 1, 64),
+			strconv.FormatFloat(o.PercentHard, 'f', 1, 64)))
+	}
+
+	return c.writeOutput(items, overColumns, rows)
+}
+
+// Write a quota event while following. json and yaml write the whole event,
+// one per line or document. Other formats write the quota.
+func (c *QuotaClient) writeEvent(event *iquota.QuotaEvent) error {
+	e := *event
+	e.Quota = c.localQuota(event.Quota)
+
+	if c.tmpl == nil {
+		switch c.Output {
+		case OutputJSON:
+			return json.NewEncoder(os.Stdout).Encode(&e)
+		case OutputYAML:
+			fmt.Println("---")
+			return writeYAML(os.Stdout, &e)
+		}
+	}
+
+	return c.writeOutput([]interface{}{e.Quota}, nil, [][]string{c.quotaRow(e.Quota)})
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ubccr/iquota"
+	"github.com/ubccr/iquota/client"
+	"github.com/urfave/cli"
+)
+
+const (
+	RequestFormat = "%-6s%-30s%-12s%12s%12s%-10s%-20s\n"
+)
+
+func requestCommand() cli.Command {
+	return cli.Command{
+		Name:  "request",
+		Usage: "Request a quota increase and review requests",
+		Subcommands: []cli.Command{
+			{
+				Name:  "submit",
+				Usage: "Request a quota increase for a directory you own",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "p,path", Usage: "Path to directory"},
+					&cli.StringFlag{Name: "limit", Usage: "New quota limit (ex. 2TB)"},
+					&cli.StringFlag{Name: "files", Usage: "New file limit (ex. 10M, default keep current limit)"},
+					&cli.StringFlag{Name: "m,justification", Usage: "Reason for the increase"},
+				},
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().submitRequest(c.String("path"), c.String("limit"), c.String("files"), c.String("justification"))
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List quota requests. Super-users see requests from all users",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "status", Usage: "Only list requests with status: pending, approved or denied"},
+				},
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().listRequests(c.String("status"))
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show details of a quota request",
+				ArgsUsage: "ID",
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().showRequest(c.Args().First())
+				},
+			},
+			{
+				Name:      "approve",
+				Usage:     "Approve a quota request and apply the new limits (super-user only)",
+				ArgsUsage: "ID",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "m,comment", Usage: "Comment on the decision"},
+				},
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().decideRequest(c.Args().First(), "approve", c.String("comment"))
+				},
+			},
+			{
+				Name:      "deny",
+				Usage:     "Deny a quota request (super-user only)",
+				ArgsUsage: "ID",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "m,comment", Usage: "Comment on the decision"},
+				},
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().decideRequest(c.Args().First(), "deny", c.String("comment"))
+				},
+			},
+		},
+	}
+}
+
+func requestError(err error) error {
+	if errors.Is(err, iquota.ErrNotFound) {
+		return errors.New("Not found")
+	}
+
+	if errors.Is(err, client.ErrUnauthorized) {
+		return errors.New("You are not authorized to access this resource")
+	}
+
+	if strings.Contains(err.Error(), "No Kerberos credentials available") {
+		return errors.New("No Kerberos credentials available. Please run kinit")
+	}
+
+	return err
+}
+
+func (c *QuotaClient) submitRequest(path, limit, files, justification string) error {
+	if len(path) == 0 {
+		return errors.New("Please provide a directory path (--path)")
+	}
+
+	path, err := c.serverPath(path)
+	if err != nil {
+		return err
+	}
+
+	if len(limit) == 0 {
+		return errors.New("Please provide the new quota limit (--limit)")
+	}
+
+	if len(strings.TrimSpace(justification)) == 0 {
+		return errors.New("Please provide a justification for the increase (--justification)")
+	}
+
+	bytes, err := humanize.ParseBytes(limit)
+	if err != nil {
+		return fmt.Errorf("Invalid quota limit value: %s", err)
+	}
+
+	req := &iquota.QuotaRequest{
+		Path:          path,
+		SoftLimit:     int(bytes),
+		Justification: justification,
+	}
+
+	if len(files) > 0 {
+		n, err := humanize.ParseBigBytes(files)
+		if err != nil || !n.IsInt64() {
+			return fmt.Errorf("Invalid file limit value: %s", files)
+		}
+		req.SoftLimitInodes = int(n.Int64())
+	}
+
+	r, err := c.api.SubmitRequest(req)
+	if err != nil {
+		return requestError(err)
+	}
+
+	fmt.Printf("Submitted request %d for %s quota on %s\n", r.ID, humanize.Bytes(uint64(r.SoftLimit)), r.Path)
+	return nil
+}
+
+func (c *QuotaClient) listRequests(status string) error {
+	requests, err := c.api.ListRequests(status)
+	if err != nil {
+		return requestError(err)
+	}
+
+	if len(requests) == 0 {
+		fmt.Println("No quota requests found")
+		return nil
+	}
+
+	fmt.Printf(RequestFormat, "ID", "Path ", "user", "current ", "requested ", "status", "created")
+	for _, r := range requests {
+		printer := yellow
+		if r.Status == iquota.RequestApproved {
+			printer = green
+		} else if r.Status == iquota.RequestDenied {
+			printer = red
+		}
+
+		printer.Printf(RequestFormat,
+			strconv.FormatInt(r.ID, 10),
+			r.Path,
+			r.User,
+			humanize.Bytes(uint64(r.CurrentSoftLimit))+" ",
+			humanize.Bytes(uint64(r.SoftLimit))+" ",
+			r.Status,
+			r.Created.Format("2006-01-02 15:04"))
+	}
+
+	return nil
+}
+
+func (c *QuotaClient) showRequest(id string) error {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return errors.New("Please provide a valid request ID")
+	}
+
+	r, err := c.api.GetRequest(n)
+	if err != nil {
+		return requestError(err)
+	}
+
+	fmt.Printf("Request:       %d\n", r.ID)
+	fmt.Printf("Path:          %s\n", r.Path)
+	fmt.Printf("User:          %s\n", r.User)
+	fmt.Printf("Created:       %s\n", r.Created.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Limit:         %s -> %s\n", humanize.Bytes(uint64(r.CurrentSoftLimit)), humanize.Bytes(uint64(r.SoftLimit)))
+	if r.SoftLimitInodes > 0 {
+		fmt.Printf("Files:         %s -> %s\n", humanize.Comma(int64(r.CurrentSoftLimitInodes)), humanize.Comma(int64(r.SoftLimitInodes)))
+	}
+	fmt.Printf("Justification: %s\n", r.Justification)
+	fmt.Printf("Status:        %s\n", r.Status)
+	if r.Reviewed != nil {
+		fmt.Printf("Reviewed:      %s by %s\n", r.Reviewed.Format("2006-01-02 15:04:05"), r.Reviewer)
+	}
+	if len(r.Comment) > 0 {
+		fmt.Printf("Comment:       %s\n", r.Comment)
+	}
+
+	return nil
+}
+
+func (c *QuotaClient) decideRequest(id, action, comment string) error {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return errors.New("Please provide a valid request ID")
+	}
+
+	var r *iquota.QuotaRequest
+	if action == "approve" {
+		r, err = c.api.ApproveRequest(n, comment)
+	} else {
+		r, err = c.api.DenyRequest(n, comment)
+	}
+	if err != nil {
+		return requestError(err)
+	}
+
+	fmt.Printf("Request %d for %s on %s %s\n", r.ID, humanize.Bytes(uint64(r.SoftLimit)), r.Path, r.Status)
+	return nil
+}
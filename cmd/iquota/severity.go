@@ -0,0 +1,110 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/ubccr/iquota"
+)
+
+// Severity of a quota's usage from least to most severe
+const (
+	SeverityNone     = "none"
+	SeverityOK       = "ok"
+	SeverityNear     = "near"
+	SeverityOverSoft = "over-soft"
+	SeverityInGrace  = "in-grace"
+	SeverityOverHard = "over-hard"
+)
+
+const DefaultBarWidth = 20
+
+var severityPrinters = map[string]*color.Color{
+	SeverityNone:     cyan,
+	SeverityOK:       green,
+	SeverityNear:     yellow,
+	SeverityOverSoft: magenta,
+	SeverityInGrace:  red,
+	SeverityOverHard: boldRed,
+}
+
+// Returns the highest percent of a limit used by bytes or inodes
+func maxPercent(q *iquota.Quota, limit string) float64 {
+	if limit == iquota.LimitHard {
+		return math.Max(q.PercentHard(iquota.UsageBytes), q.PercentHard(iquota.UsageInodes))
+	}
+
+	return math.Max(q.PercentSoft(iquota.UsageBytes), q.PercentSoft(iquota.UsageInodes))
+}
+
+// Severity of the usage on q for whichever of bytes or inodes is worse. Usage
+// is near at NearPercent of the soft limit or NearHardPercent of the hard
+// limit. Over the soft limit it is in-grace if the grace period is running.
+func (c *QuotaClient) severity(q *iquota.Quota) string {
+	if q.SoftLimit <= 0 && q.HardLimit <= 0 && q.SoftLimitInodes <= 0 && q.HardLimitInodes <= 0 {
+		return SeverityNone
+	}
+
+	soft := maxPercent(q, iquota.LimitSoft)
+	hard := maxPercent(q, iquota.LimitHard)
+
+	switch {
+	case hard >= 100:
+		return SeverityOverHard
+	case soft >= 100 && len(q.GraceExpiration) > 0:
+		return SeverityInGrace
+	case soft >= 100:
+		return SeverityOverSoft
+	case c.NearPercent > 0 && soft >= c.NearPercent,
+		c.NearHardPercent > 0 && hard >= c.NearHardPercent:
+		return SeverityNear
+	}
+
+	return SeverityOK
+}
+
+func (c *QuotaClient) printer(q *iquota.Quota) *color.Color {
+	return severityPrinters[c.severity(q)]
+}
+
+// Format a percent of a limit. Blank if there is no limit
+func percentString(q *iquota.Quota, limit string) string {
+	if limit == iquota.LimitHard && q.HardLimit <= 0 && q.HardLimitInodes <= 0 {
+		return ""
+	}
+	if limit == iquota.LimitSoft && q.SoftLimit <= 0 && q.SoftLimitInodes <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%.0f%%", maxPercent(q, limit))
+}
+
+// Bar showing the percent of the soft limit used, or the hard limit if there
+// is no soft limit. Full bars are capped at width. Blank if there are no
+// limits.
+func usageBar(q *iquota.Quota, width int) string {
+	if width <= 0 {
+		width = DefaultBarWidth
+	}
+
+	pct := maxPercent(q, iquota.LimitSoft)
+	if q.SoftLimit <= 0 && q.SoftLimitInodes <= 0 {
+		if q.HardLimit <= 0 && q.HardLimitInodes <= 0 {
+			return ""
+		}
+		pct = maxPercent(q, iquota.LimitHard)
+	}
+
+	filled := int(math.Round(pct / 100 * float64(width)))
+	if filled > width {
+		filled = width
+	}
+
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ubccr/iquota"
+)
+
+func TestSeverity(t *testing.T) {
+	c := &QuotaClient{NearPercent: 90, NearHardPercent: 80}
+
+	tests := []struct {
+		quota iquota.Quota
+		want  string
+	}{
+		{iquota.Quota{Used: 100}, SeverityNone},
+		{iquota.Quota{Used: 50, SoftLimit: 100, HardLimit: 200}, SeverityOK},
+		{iquota.Quota{Used: 90, SoftLimit: 100, HardLimit: 200}, SeverityNear},
+		// Near the hard limit without a soft limit
+		{iquota.Quota{Used: 80, HardLimit: 100}, SeverityNear},
+		{iquota.Quota{Used: 10, SoftLimit: 100, UsedInodes: 95, SoftLimitInodes: 100}, SeverityNear},
+		{iquota.Quota{Used: 100, SoftLimit: 100, HardLimit: 200}, SeverityOverSoft},
+		{iquota.Quota{Used: 110, SoftLimit: 100, HardLimit: 200, GraceExpiration: "6 23:00:00"}, SeverityInGrace},
+		{iquota.Quota{Used: 200, SoftLimit: 100, HardLimit: 200, GraceExpiration: "6 23:00:00"}, SeverityOverHard},
+		{iquota.Quota{Used: 10, SoftLimit: 100, UsedInodes: 100, SoftLimitInodes: 50, HardLimitInodes: 100}, SeverityOverHard},
+	}
+
+	for _, test := range tests {
+		if got := c.severity(&test.quota); got != test.want {
+			t.Errorf("severity(%+v) = %s, want %s", test.quota, got, test.want)
+		}
+	}
+
+	// Near thresholds of 0 disable the near severity
+	c = &QuotaClient{}
+	if got := c.severity(&iquota.Quota{Used: 99, SoftLimit: 100}); got != SeverityOK {
+		t.Errorf("severity with near disabled = %s, want %s", got, SeverityOK)
+	}
+}
+
+func TestUsageBar(t *testing.T) {
+	tests := []struct {
+		quota iquota.Quota
+		width int
+		want  string
+	}{
+		{iquota.Quota{Used: 50, SoftLimit: 100}, 10, "[#####.....]"},
+		{iquota.Quota{Used: 0, SoftLimit: 100}, 4, "[....]"},
+		{iquota.Quota{Used: 100, SoftLimit: 100}, 4, "[####]"},
+		// Capped at the width when over the limit
+		{iquota.Quota{Used: 300, SoftLimit: 100}, 4, "[####]"},
+		// Worse of bytes and inodes
+		{iquota.Quota{Used: 10, SoftLimit: 100, UsedInodes: 75, SoftLimitInodes: 100}, 4, "[###.]"},
+		// Hard limit if there is no soft limit
+		{iquota.Quota{Used: 25, HardLimit: 100}, 4, "[#...]"},
+		{iquota.Quota{Used: 25}, 4, ""},
+		{iquota.Quota{Used: 50, SoftLimit: 100}, 0, "[##########..........]"},
+	}
+
+	for _, test := range tests {
+		if got := usageBar(&test.quota, test.width); got != test.want {
+			t.Errorf("usageBar(%+v, %d) = %q, want %q", test.quota, test.width, got, test.want)
+		}
+	}
+}
+
+func TestPercentString(t *testing.T) {
+	q := &iquota.Quota{Used: 50, SoftLimit: 100, UsedInodes: 60, SoftLimitInodes: 100}
+	if got := percentString(q, iquota.LimitSoft); got != "60%" {
+		t.Errorf("percentString soft = %q, want 60%%", got)
+	}
+	if got := percentString(q, iquota.LimitHard); got != "" {
+		t.Errorf("percentString hard without a limit = %q, want blank", got)
+	}
+}
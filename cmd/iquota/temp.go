@@ -0,0 +1,160 @@
+// Copyright 2015 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ubccr/iquota"
+	"github.com/urfave/cli"
+)
+
+const (
+	TempFormat = "%-30s%12s%12s%-18s%-12s%-30s\n"
+)
+
+func tempCommand() cli.Command {
+	return cli.Command{
+		Name:  "temp",
+		Usage: "Manage temporary quota increases (super-user only)",
+		Subcommands: []cli.Command{
+			{
+				Name:  "set",
+				Usage: "Temporarily increase a quota. The original limits are restored at expiry",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "p,path", Usage: "Path to directory"},
+					&cli.StringFlag{Name: "limit", Usage: "Temporary quota limit (ex. 2TB)"},
+					&cli.StringFlag{Name: "files", Usage: "Temporary file limit (ex. 10M, default keep current limit)"},
+					&cli.IntFlag{Name: "days", Usage: "Number of days until the original limits are restored", Value: 14},
+					&cli.StringFlag{Name: "m,reason", Usage: "Reason for the increase"},
+				},
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().setTempIncrease(c.String("path"), c.String("limit"), c.String("files"), c.Int("days"), c.String("reason"))
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List temporary quota increases",
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().listTempIncreases()
+				},
+			},
+			{
+				Name:  "revert",
+				Usage: "Restore the original limits now",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "p,path", Usage: "Path to directory"},
+				},
+				Action: func(c *cli.Context) error {
+					return newQuotaClient().revertTempIncrease(c.String("path"))
+				},
+			},
+		},
+	}
+}
+
+func (c *QuotaClient) setTempIncrease(path, limit, files string, days int, reason string) error {
+	if len(path) == 0 {
+		return errors.New("Please provide a directory path (--path)")
+	}
+
+	path, err := c.serverPath(path)
+	if err != nil {
+		return err
+	}
+
+	if len(limit) == 0 {
+		return errors.New("Please provide the temporary quota limit (--limit)")
+	}
+
+	if days <= 0 {
+		return errors.New("Number of days must be positive")
+	}
+
+	bytes, err := humanize.ParseBytes(limit)
+	if err != nil {
+		return fmt.Errorf("Invalid quota limit value: %s", err)
+	}
+
+	req := &iquota.TempIncrease{
+		Path:    path,
+		Limits:  iquota.Limits{SoftLimit: int(bytes)},
+		Expires: time.Now().AddDate(0, 0, days),
+		Reason:  reason,
+	}
+
+	if len(files) > 0 {
+		n, err := humanize.ParseBigBytes(files)
+		if err != nil || !n.IsInt64() {
+			return fmt.Errorf("Invalid file limit value: %s", files)
+		}
+		req.Limits.SoftLimitInodes = int(n.Int64())
+	}
+
+	t, err := c.api.SetTempIncrease(req)
+	if err != nil {
+		return requestError(err)
+	}
+
+	fmt.Printf("Set %s quota on %s until %s (was %s)\n",
+		humanize.Bytes(uint64(t.Limits.SoftLimit)),
+		t.Path,
+		t.Expires.Format("2006-01-02 15:04"),
+		humanize.Bytes(uint64(t.Original.SoftLimit)))
+
+	return nil
+}
+
+func (c *QuotaClient) listTempIncreases() error {
+	increases, err := c.api.ListTempIncreases()
+	if err != nil {
+		return requestError(err)
+	}
+
+	if len(increases) == 0 {
+		fmt.Println("No temporary quota increases found")
+		return nil
+	}
+
+	fmt.Printf(TempFormat, "Path ", "limit ", "original ", "expires", "by", "reason")
+	for _, t := range increases {
+		printer := cyan
+		if t.Warned {
+			printer = yellow
+		}
+
+		printer.Printf(TempFormat,
+			t.Path,
+			humanize.Bytes(uint64(t.Limits.SoftLimit))+" ",
+			humanize.Bytes(uint64(t.Original.SoftLimit))+" ",
+			t.Expires.Format("2006-01-02 15:04"),
+			t.User,
+			t.Reason)
+	}
+
+	return nil
+}
+
+func (c *QuotaClient) revertTempIncrease(path string) error {
+	if len(path) == 0 {
+		return errors.New("Please provide a directory path (--path)")
+	}
+
+	path, err := c.serverPath(path)
+	if err != nil {
+		return err
+	}
+
+	t, err := c.api.RevertTempIncrease(path)
+	if err != nil {
+		return requestError(err)
+	}
+
+	fmt.Printf("Restored %s quota on %s\n", humanize.Bytes(uint64(t.Original.SoftLimit)), t.Path)
+	return nil
+}
@@ -1,55 +1,21 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/ubccr/iquota"
+	"github.com/ubccr/iquota/vast"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-/*
-   Example vast api payload
-   {
-       "cluster": "",
-       "cluster_id": 1,
-       "grace_period": "7 00:00:00",
-       "guid": "",
-       "hard_limit": 11000000000,
-       "hard_limit_inodes": 10100000,
-       "id": 1441,
-       "internal": false,
-       "name": "",
-       "path": "",
-       "pretty_grace_period": " 7 days",
-       "pretty_grace_period_expiration": null,
-       "pretty_state": "OK",
-       "soft_limit": 10000000000,
-       "soft_limit_inodes": 10000000,
-       "state": "OK",
-       "sync_state": "SYNCHRONIZED",
-       "tenant_id": 1,
-       "time_to_block": null,
-       "title": "",
-       "url": "",
-       "used_capacity": 1038804300,
-       "used_capacity_tb": 0.001,
-       "used_effective_capacity": 1043824640,
-       "used_effective_capacity_tb": 0.001,
-       "used_inodes": 282
-   },
-*/
-
 const (
 	LongFormat = "%-30s%15s%15s%10s%10s%12s\n"
 )
@@ -58,7 +24,7 @@ var (
 	DefaultUserQuotaLimit = uint64(25000000000)   //  25G
 	DefaultUserFilesLimit = uint64(10000000)      //  10M
 	DefaultQuotaLimit     = uint64(1000000000000) //   1T
-	DefaultFilesLimit     = uint64(200000000)     // 200M
+	DefaultFilesLimit     = uint64(iquota.DefaultFilesLimit)
 
 	debug = kingpin.Flag("debug", "enable debug mode").Default("false").Bool()
 
@@ -77,6 +43,11 @@ var (
 		"Vast password",
 	).Envar("VAST_PASSWORD").String()
 
+	backendName = kingpin.Flag(
+		"backend",
+		"Backend name of vast in the iquota.yaml routing table",
+	).Default("vast").Envar("IVAST_BACKEND").String()
+
 	cmdGetQuota  = kingpin.Command("get-quota", "Get VAST quota")
 	getQuotaPath = cmdGetQuota.Flag(
 		"path",
@@ -103,117 +74,46 @@ var (
 	cmdUserCheck = kingpin.Command("user-check", "Check and set user home directories")
 )
 
-type vastQuota struct {
-	ID                    int    `json:"id"`
-	HardLimit             int    `json:"hard_limit"`
-	HardLimitInodes       int    `json:"hard_limit_inodes"`
-	Path                  string `json:"path"`
-	GracePeriod           string `json:"pretty_grace_period"`
-	SoftLimit             int    `json:"soft_limit"`
-	SoftLimitInodes       int    `json:"soft_limit_inodes"`
-	State                 string `json:"state"`
-	SyncState             string `json:"sync_state"`
-	UsedCapacity          int    `json:"used_capacity"`
-	UsedEffectiveCapacity int    `json:"used_effective_capacity"`
-	UsedInodes            int    `json:"used_inodes"`
-}
-
 func init() {
 	viper.SetConfigName("iquota")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("/etc/iquota/")
 }
 
-func fetchQuotaReport(dirPath string) ([]vastQuota, error) {
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	client := &http.Client{Transport: tr}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/api/quotas/", *vastHost), nil)
-	if len(dirPath) > 0 {
-		params := req.URL.Query()
-		params.Add("path", dirPath)
-		req.URL.RawQuery = params.Encode()
-	}
-
-	req.Header.Set("Accept", "application/json")
-	req.SetBasicAuth(*vastUser, *vastPass)
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("Failed to fetch vast quota with HTTP status code: %d", res.StatusCode)
-	}
+func vastClient() *vast.Client {
+	return &vast.Client{Host: *vastHost, User: *vastUser, Password: *vastPass}
+}
 
-	rawJson, err := ioutil.ReadAll(res.Body)
+// Routes to vast from the routing table in iquota.yaml
+func vastRoutes() iquota.Routes {
+	routes, err := iquota.NewRoutes(viper.GetViper(), "routes")
 	if err != nil {
-		return nil, err
+		log.Fatalf("Failed to read routing table: %s", err)
 	}
 
-	var vq []vastQuota
-	err = json.Unmarshal(rawJson, &vq)
-	if err != nil {
-		return nil, err
+	var own iquota.Routes
+	for _, r := range routes {
+		if r.Backend == *backendName {
+			own = append(own, r)
+		}
 	}
 
-	return vq, nil
-}
-
-func createVastDirectoryQuota(path string, softLimit, filesLimit uint64) error {
-	apiUrl := fmt.Sprintf("https://%s/api/quotas/", *vastHost)
-	return setVastDirectoryQuota("POST", apiUrl, path, softLimit, filesLimit)
+	return own
 }
 
-func updateVastDirectoryQuota(quota vastQuota, path string, softLimit, filesLimit uint64) error {
-	apiUrl := fmt.Sprintf("https://%s/api/quotas/%d/", *vastHost, quota.ID)
-	return setVastDirectoryQuota("PATCH", apiUrl, path, softLimit, filesLimit)
-}
-
-func setVastDirectoryQuota(verb, apiUrl, path string, softLimit, filesLimit uint64) error {
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	client := &http.Client{Transport: tr}
-
-	payload := map[string]interface{}{
-		"name":              path,
-		"path":              path,
-		"grace_period":      "7 00:00:00",
-		"soft_limit":        softLimit,
-		"hard_limit":        softLimit + 1000000000, // add 1G
-		"soft_limit_inodes": filesLimit,
-		"hard_limit_inodes": filesLimit + 100000, // add 100K
-		"create_dir":        "False",
-	}
-
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest(verb, apiUrl, bytes.NewBuffer(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(*vastUser, *vastPass)
-	res, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == 201 || res.StatusCode == 200 {
-		return nil
+// Translates a mounted path to the path vast knows it by
+func storagePath(routes iquota.Routes, p string) string {
+	if r, ok := routes.Match(p); ok {
+		return r.ToStorage(p)
 	}
 
-	rawBody, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
+	return p
+}
 
-	return fmt.Errorf("Failed with code %d createing vast quota for path %s: %s", res.StatusCode, path, string(rawBody))
+// Translates a vast path to the mounted path
+func mountPath(routes iquota.Routes, p string) string {
+	p, _ = routes.FromStorage(*backendName, p)
+	return p
 }
 
 func setDirectoryQuota() {
@@ -247,19 +147,10 @@ func setDirectoryQuota() {
 		}
 	}
 
-	quotas, err := fetchQuotaReport(dirPath)
-	if err != nil {
-		log.Fatalf("Failed to check quota report for %s: %s", dirPath, err)
-	}
-
-	if len(quotas) == 0 {
-		err = createVastDirectoryQuota(dirPath, bytes, DefaultFilesLimit)
-	} else if len(quotas) == 1 {
-		err = updateVastDirectoryQuota(quotas[0], dirPath, bytes, DefaultFilesLimit)
-	} else {
-		log.Fatalf("Failed to set quota. More than one already exists: ", dirPath)
-	}
-
+	err = vastClient().SetQuota(storagePath(vastRoutes(), dirPath), &iquota.Limits{
+		SoftLimit:       int(bytes),
+		SoftLimitInodes: int(DefaultFilesLimit),
+	})
 	if err != nil {
 		log.Fatalf("Failed to set quota on %s: %s", dirPath, err)
 	}
@@ -274,7 +165,7 @@ func checkAndSetUserQuotas() {
 
 	log.Infof("Checking all directories under: %s", viper.GetString("home_dir"))
 
-	quotas, err := fetchQuotaReport("")
+	quotas, err := vastClient().FetchQuotas("")
 	if err != nil {
 		log.Fatalf("Failed to fetch all quotas from vast: %s", err)
 	}
@@ -283,9 +174,10 @@ func checkAndSetUserQuotas() {
 		log.Fatalf("Something is a bit off, we got an very small number of quotas so we're going to bail here")
 	}
 
+	routes := vastRoutes()
 	qmap := make(map[string]bool)
 	for _, q := range quotas {
-		qmap[q.Path] = true
+		qmap[mountPath(routes, q.Path)] = true
 	}
 
 	files, err := ioutil.ReadDir(viper.GetString("home_dir"))
@@ -293,6 +185,7 @@ func checkAndSetUserQuotas() {
 		log.Fatalf("Failed to list user directories: %s", err)
 	}
 
+	client := vastClient()
 	count := 0
 	for _, file := range files {
 		if !file.IsDir() {
@@ -309,7 +202,10 @@ func checkAndSetUserQuotas() {
 		}
 
 		log.Infof("Setting new user quota on path: %s", abspath)
-		err := createVastDirectoryQuota(abspath, DefaultUserQuotaLimit, DefaultUserFilesLimit)
+		err := client.CreateQuota(storagePath(routes, abspath), &iquota.Limits{
+			SoftLimit:       int(DefaultUserQuotaLimit),
+			SoftLimitInodes: int(DefaultUserFilesLimit),
+		})
 		if err != nil {
 			log.Errorf("%s", err)
 		} else {
@@ -334,7 +230,8 @@ func getDirectoryQuota() {
 		log.Fatalf("Paths must be absolute: %s", *quotaPath)
 	}
 
-	quotas, err := fetchQuotaReport(dirPath)
+	routes := vastRoutes()
+	quotas, err := vastClient().FetchQuotas(storagePath(routes, dirPath))
 	if err != nil {
 		log.Fatalf("Failed to fetch quota report for %s: %s", dirPath, err)
 	}
@@ -342,7 +239,7 @@ func getDirectoryQuota() {
 	fmt.Printf(LongFormat, "Path ", "files", "used", "soft", "hard", "grace ")
 	for _, q := range quotas {
 		fmt.Printf(LongFormat,
-			q.Path,
+			mountPath(routes, q.Path),
 			humanize.Comma(int64(q.UsedInodes)),
 			humanize.Bytes(uint64(q.UsedEffectiveCapacity)),
 			humanize.Bytes(uint64(q.SoftLimit)),
@@ -352,36 +249,44 @@ func getDirectoryQuota() {
 }
 
 func cacheQuotas() {
-	quotas, err := fetchQuotaReport("")
+	start := time.Now()
+	cache := &iquota.Cache{Expire: *expire}
+
+	quotas, err := vastClient().FetchQuotas("")
 	if err != nil {
+		cache.RecordCommandRun("vast", start, 0, err)
 		log.Fatalf("Failed to fetch quota report from vast: %s", err)
 	}
 
 	log.Infof("Found %d quotas from vast", len(quotas))
 
-	cache := &iquota.Cache{Expire: *expire}
+	routes := vastRoutes()
+	count := 0
 
 	for _, q := range quotas {
-		iq := &iquota.Quota{
-			Path:            q.Path,
-			GracePeriod:     q.GracePeriod,
-			HardLimit:       q.HardLimit,
-			SoftLimit:       q.SoftLimit,
-			Used:            q.UsedEffectiveCapacity,
-			HardLimitInodes: q.HardLimitInodes,
-			SoftLimitInodes: q.SoftLimitInodes,
-			UsedInodes:      q.UsedInodes,
-		}
-
-		err := cache.SetDirectoryQuotaCache(q.Path, iq)
+		iq := q.ToQuota()
+		iq.Path = mountPath(routes, q.Path)
+		err := cache.SetDirectoryQuotaCache(iq.Path, iq)
 		if err != nil {
 			log.WithFields(log.Fields{
-				"path":  q.Path,
+				"path":  iq.Path,
 				"error": err,
 			}).Error("Failed to set vast directory quota cache in redis")
+			continue
 		}
 
-		log.Infof("Successfully cached %s quota for %s", humanize.Bytes(uint64(q.SoftLimit)), q.Path)
+		err = cache.AddHistory(iq, start)
+		if err != nil {
+			log.Errorf("Failed to record quota history for %s: %s", iq.Path, err)
+		}
+
+		count++
+		log.Infof("Successfully cached %s quota for %s", humanize.Bytes(uint64(q.SoftLimit)), iq.Path)
+	}
+
+	err = cache.RecordCommandRun("vast", start, count, nil)
+	if err != nil {
+		log.Errorf("Failed to record vast collector status: %s", err)
 	}
 }
 
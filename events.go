@@ -0,0 +1,85 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"time"
+)
+
+const (
+	EventUsage     = "usage"
+	EventLimits    = "limits"
+	EventThreshold = "threshold"
+
+	LevelOK   = "ok"
+	LevelWarn = "warn"
+	LevelSoft = "soft"
+	LevelHard = "hard"
+)
+
+// Change to a cached directory quota
+type QuotaEvent struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Quota *Quota    `json:"quota"`
+
+	// Threshold level of the quota before and after a threshold event
+	Level         string `json:"level,omitempty"`
+	PreviousLevel string `json:"previous_level,omitempty"`
+}
+
+// Level returns the highest threshold crossed by either bytes or inodes:
+// LevelHard if over the hard limit, LevelSoft if over the soft limit and
+// LevelWarn if over warnPercent of the soft limit.
+func (q *Quota) Level(warnPercent float64) string {
+	soft := q.PercentSoft(UsageBytes)
+	if p := q.PercentSoft(UsageInodes); p > soft {
+		soft = p
+	}
+
+	hard := q.PercentHard(UsageBytes)
+	if p := q.PercentHard(UsageInodes); p > hard {
+		hard = p
+	}
+
+	if hard >= 100 {
+		return LevelHard
+	} else if soft >= 100 {
+		return LevelSoft
+	} else if warnPercent > 0 && soft >= warnPercent {
+		return LevelWarn
+	}
+
+	return LevelOK
+}
+
+// DiffQuota returns the events for a change from old to cur at time t. If old
+// is nil cur is a new quota.
+func DiffQuota(old, cur *Quota, warnPercent float64, t time.Time) []*QuotaEvent {
+	events := make([]*QuotaEvent, 0)
+
+	if old == nil || old.QuotaLimits() != cur.QuotaLimits() {
+		events = append(events, &QuotaEvent{Type: EventLimits, Time: t, Quota: cur})
+	} else if old.Used != cur.Used || old.UsedInodes != cur.UsedInodes {
+		events = append(events, &QuotaEvent{Type: EventUsage, Time: t, Quota: cur})
+	}
+
+	prev := LevelOK
+	if old != nil {
+		prev = old.Level(warnPercent)
+	}
+
+	if level := cur.Level(warnPercent); level != prev {
+		events = append(events, &QuotaEvent{
+			Type:          EventThreshold,
+			Time:          t,
+			Quota:         cur,
+			Level:         level,
+			PreviousLevel: prev,
+		})
+	}
+
+	return events
+}
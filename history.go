@@ -0,0 +1,130 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	historyKeyPrefix = "iquota:history:"
+
+	DefaultHistoryInterval  = time.Hour
+	DefaultHistoryRetention = 90 * 24 * time.Hour
+)
+
+// Usage of a directory quota at a point in time
+type HistorySample struct {
+	Time       time.Time `json:"time"`
+	Used       int       `json:"used"`
+	UsedInodes int       `json:"used_inodes"`
+	SoftLimit  int       `json:"soft_limit"`
+	HardLimit  int       `json:"hard_limit"`
+}
+
+// Minimum time between history samples. Set by history.interval in seconds
+func historyInterval() time.Duration {
+	if viper.IsSet("history.interval") {
+		return time.Duration(viper.GetInt("history.interval")) * time.Second
+	}
+
+	return DefaultHistoryInterval
+}
+
+// How long to keep history samples. Set by history.retention in days
+func historyRetention() time.Duration {
+	if viper.IsSet("history.retention") {
+		return time.Duration(viper.GetInt("history.retention")) * 24 * time.Hour
+	}
+
+	return DefaultHistoryRetention
+}
+
+// AddHistory records the usage of quota at time t. At most one sample is kept
+// per history interval and samples older than the retention period are
+// removed. Set history.interval to 0 to disable.
+func (c *Cache) AddHistory(quota *Quota, t time.Time) error {
+	interval := historyInterval()
+	if interval <= 0 {
+		return nil
+	}
+
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := historyKeyPrefix + quota.Path
+
+	// Reply is the latest member followed by its score (unix time)
+	last, err := redis.Strings(conn.Do("ZREVRANGEBYSCORE", key, "+inf", "-inf", "WITHSCORES", "LIMIT", 0, 1))
+	if err != nil {
+		return err
+	}
+	if len(last) == 2 {
+		ts, err := strconv.ParseInt(last[1], 10, 64)
+		if err == nil && t.Unix()-ts < int64(interval.Seconds()) {
+			return nil
+		}
+	}
+
+	out, err := json.Marshal(&HistorySample{
+		Time:       t,
+		Used:       quota.Used,
+		UsedInodes: quota.UsedInodes,
+		SoftLimit:  quota.SoftLimit,
+		HardLimit:  quota.HardLimit,
+	})
+	if err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("ZADD", key, t.Unix(), out)
+	conn.Send("ZREMRANGEBYSCORE", key, "-inf", t.Add(-historyRetention()).Unix())
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":  err.Error(),
+			"path": quota.Path,
+		}).Error("Failed to save quota history")
+		return err
+	}
+
+	return nil
+}
+
+// GetHistory returns the usage history for path since the given time ordered
+// oldest first
+func (c *Cache) GetHistory(path string, since time.Time) ([]*HistorySample, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	members, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", historyKeyPrefix+path, since.Unix(), "+inf"))
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]*HistorySample, 0, len(members))
+	for _, m := range members {
+		s := &HistorySample{}
+		if err := json.Unmarshal(m, s); err != nil {
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, nil
+}
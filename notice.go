@@ -0,0 +1,100 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	noticeKeyPrefix = "iquota:notice:"
+)
+
+// Quota warning sent to the owners of a directory. Kept until a recovery
+// notice is sent so owners are only warned again if the quota gets worse.
+type Notice struct {
+	Path       string    `json:"path"`
+	Level      string    `json:"level"`
+	Recipients []string  `json:"recipients"`
+	Sent       time.Time `json:"sent"`
+}
+
+// SetNotice saves the last warning sent for n.Path
+func (c *Cache) SetNotice(n *Notice) error {
+	out, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", noticeKeyPrefix+n.Path, out)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":  err.Error(),
+			"path": n.Path,
+		}).Error("Failed to save notice")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteNotice removes the warning sent for path
+func (c *Cache) DeleteNotice(path string) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", noticeKeyPrefix+path)
+	return err
+}
+
+// ListNotices returns the warnings sent for all directories still awaiting
+// recovery
+func (c *Cache) ListNotices() ([]*Notice, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", noticeKeyPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	notices := make([]*Notice, 0, len(keys))
+	for _, key := range keys {
+		rawJson, err := redis.Bytes(conn.Do("GET", key))
+		if err != nil {
+			continue
+		}
+
+		n := &Notice{}
+		err = json.Unmarshal(rawJson, n)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"key": key,
+			}).Error("Invalid notice")
+			continue
+		}
+
+		notices = append(notices, n)
+	}
+
+	return notices, nil
+}
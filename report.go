@@ -0,0 +1,92 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"sort"
+)
+
+const (
+	UsageBytes  = "bytes"
+	UsageInodes = "inodes"
+
+	LimitSoft = "soft"
+	LimitHard = "hard"
+)
+
+// Quota that has crossed a reporting threshold. Percentages are the maximum
+// across the usage types considered (bytes and/or inodes).
+type OverQuota struct {
+	*Quota
+	PercentSoft float64 `json:"percent_soft"`
+	PercentHard float64 `json:"percent_hard"`
+}
+
+func percent(used, limit int) float64 {
+	if limit <= 0 {
+		return 0
+	}
+
+	return float64(used) / float64(limit) * 100
+}
+
+// Percent of the soft limit used for the given usage type (bytes or inodes)
+func (q *Quota) PercentSoft(usageType string) float64 {
+	if usageType == UsageInodes {
+		return percent(q.UsedInodes, q.SoftLimitInodes)
+	}
+
+	return percent(q.Used, q.SoftLimit)
+}
+
+// Percent of the hard limit used for the given usage type (bytes or inodes)
+func (q *Quota) PercentHard(usageType string) float64 {
+	if usageType == UsageInodes {
+		return percent(q.UsedInodes, q.HardLimitInodes)
+	}
+
+	return percent(q.Used, q.HardLimit)
+}
+
+// FindOverQuota returns all quotas whose usage is at or above pct percent of
+// their soft (or hard) limit, sorted by severity with the worst offenders
+// first. If usageType is empty both bytes and inodes are considered.
+func FindOverQuota(quotas []*Quota, pct float64, limit, usageType string) []*OverQuota {
+	usageTypes := []string{UsageBytes, UsageInodes}
+	if len(usageType) > 0 {
+		usageTypes = []string{usageType}
+	}
+
+	over := make([]*OverQuota, 0)
+	for _, q := range quotas {
+		oq := &OverQuota{Quota: q}
+		for _, t := range usageTypes {
+			if p := q.PercentSoft(t); p > oq.PercentSoft {
+				oq.PercentSoft = p
+			}
+			if p := q.PercentHard(t); p > oq.PercentHard {
+				oq.PercentHard = p
+			}
+		}
+
+		check := oq.PercentSoft
+		if limit == LimitHard {
+			check = oq.PercentHard
+		}
+
+		if check > 0 && check >= pct {
+			over = append(over, oq)
+		}
+	}
+
+	sort.SliceStable(over, func(i, j int) bool {
+		if over[i].PercentHard != over[j].PercentHard {
+			return over[i].PercentHard > over[j].PercentHard
+		}
+		return over[i].PercentSoft > over[j].PercentSoft
+	})
+
+	return over
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindOverQuota(t *testing.T) {
+	quotas := []*Quota{
+		// 95% of soft bytes, 50% of hard bytes
+		{Path: "/near", Used: 95, SoftLimit: 100, HardLimit: 190},
+		// 120% of soft bytes, 100% of hard bytes
+		{Path: "/hard", Used: 120, SoftLimit: 100, HardLimit: 120},
+		// Bytes fine, 150% of soft inodes, 75% of hard inodes
+		{Path: "/files", Used: 10, SoftLimit: 100, HardLimit: 200, UsedInodes: 150, SoftLimitInodes: 100, HardLimitInodes: 200},
+		// 50% of soft bytes
+		{Path: "/ok", Used: 50, SoftLimit: 100, HardLimit: 200},
+		// No limits at all
+		{Path: "/unlimited", Used: 1000, UsedInodes: 1000},
+		// No soft limit, 90% of hard bytes
+		{Path: "/hardonly", Used: 90, HardLimit: 100},
+	}
+
+	tests := []struct {
+		pct       float64
+		limit     string
+		usageType string
+		want      string
+	}{
+		{90, LimitSoft, "", "/hard,/files,/near"},
+		{90, LimitSoft, UsageBytes, "/hard,/near"},
+		{90, LimitSoft, UsageInodes, "/files"},
+		{100, LimitSoft, "", "/hard,/files"},
+		{90, LimitHard, "", "/hard,/hardonly"},
+		{100, LimitHard, UsageBytes, "/hard"},
+		{70, LimitHard, UsageInodes, "/files"},
+		// Quotas without the limit are never over it
+		{0, LimitSoft, "", "/hard,/files,/near,/ok"},
+		{0, LimitHard, UsageInodes, "/files"},
+		{200, LimitSoft, "", ""},
+	}
+
+	for _, test := range tests {
+		over := FindOverQuota(quotas, test.pct, test.limit, test.usageType)
+
+		paths := make([]string, 0, len(over))
+		for _, o := range over {
+			paths = append(paths, o.Path)
+		}
+
+		if got := strings.Join(paths, ","); got != test.want {
+			t.Errorf("FindOverQuota(%.0f, %s, %q) = %s, want %s", test.pct, test.limit, test.usageType, got, test.want)
+		}
+	}
+}
+
+func TestFindOverQuotaPercents(t *testing.T) {
+	q := &Quota{Path: "/mixed", Used: 80, SoftLimit: 100, HardLimit: 400, UsedInodes: 90, SoftLimitInodes: 100, HardLimitInodes: 100}
+
+	tests := []struct {
+		usageType string
+		soft      float64
+		hard      float64
+	}{
+		{"", 90, 90},
+		{UsageBytes, 80, 20},
+		{UsageInodes, 90, 90},
+	}
+
+	for _, test := range tests {
+		over := FindOverQuota([]*Quota{q}, 0, LimitSoft, test.usageType)
+		if len(over) != 1 {
+			t.Fatalf("%q: expected 1 quota got %d", test.usageType, len(over))
+		}
+		if over[0].PercentSoft != test.soft || over[0].PercentHard != test.hard {
+			t.Errorf("%q: expected soft %.0f%% hard %.0f%% got soft %.0f%% hard %.0f%%",
+				test.usageType, test.soft, test.hard, over[0].PercentSoft, over[0].PercentHard)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestDenied   = "denied"
+
+	requestKeyPrefix = "iquota:request:"
+	requestSeqKey    = "iquota:request_seq"
+)
+
+// Request to increase the quota limits on a directory
+type QuotaRequest struct {
+	ID            int64     `json:"id"`
+	Path          string    `json:"path"`
+	User          string    `json:"user"`
+	Justification string    `json:"justification"`
+	Status        string    `json:"status"`
+	Created       time.Time `json:"created"`
+
+	// Requested limits. A zero inode limit keeps the current inode limit
+	SoftLimit       int `json:"soft_limit"`
+	SoftLimitInodes int `json:"soft_limit_inodes,omitempty"`
+
+	// Limits at the time the request was submitted
+	CurrentSoftLimit       int `json:"current_soft_limit"`
+	CurrentSoftLimitInodes int `json:"current_soft_limit_inodes"`
+
+	// Admin decision
+	Reviewer string     `json:"reviewer,omitempty"`
+	Reviewed *time.Time `json:"reviewed,omitempty"`
+	Comment  string     `json:"comment,omitempty"`
+}
+
+// Admin decision on a quota request
+type RequestDecision struct {
+	Comment string `json:"comment"`
+}
+
+// Limits returns the quota limits to apply when the request is approved given
+// the current quota. Current hard limits are kept unless the new soft limit
+// is above them, in which case the backend picks a new hard limit.
+func (r *QuotaRequest) Limits(current *Quota) *Limits {
+	l := &Limits{
+		SoftLimit:       r.SoftLimit,
+		SoftLimitInodes: r.SoftLimitInodes,
+	}
+	if current == nil {
+		return l
+	}
+
+	if l.SoftLimitInodes == 0 {
+		l.SoftLimitInodes = current.SoftLimitInodes
+	}
+	if current.HardLimit >= l.SoftLimit {
+		l.HardLimit = current.HardLimit
+	}
+	if current.HardLimitInodes >= l.SoftLimitInodes {
+		l.HardLimitInodes = current.HardLimitInodes
+	}
+
+	return l
+}
+
+func (c *Cache) saveRequest(conn redis.Conn, r *QuotaRequest) error {
+	out, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// Requests are the record of all quota changes so are never expired
+	_, err = conn.Do("SET", fmt.Sprintf("%s%d", requestKeyPrefix, r.ID), out)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+			"id":  r.ID,
+		}).Error("Failed to save quota request")
+		return err
+	}
+
+	return nil
+}
+
+// CreateRequest assigns the next request ID to r and saves it
+func (c *Cache) CreateRequest(r *QuotaRequest) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.ID, err = redis.Int64(conn.Do("INCR", requestSeqKey))
+	if err != nil {
+		return err
+	}
+
+	r.Status = RequestPending
+	r.Created = time.Now()
+
+	return c.saveRequest(conn, r)
+}
+
+// UpdateRequest saves changes to an existing request
+func (c *Cache) UpdateRequest(r *QuotaRequest) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return c.saveRequest(conn, r)
+}
+
+func (c *Cache) unmarshalRequest(conn redis.Conn, key string) (*QuotaRequest, error) {
+	rawJson, err := redis.Bytes(conn.Do("GET", key))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	r := &QuotaRequest{}
+	err = json.Unmarshal(rawJson, r)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid quota request %s: %w", key, err)
+	}
+
+	return r, nil
+}
+
+// GetRequest returns the request with the given ID
+func (c *Cache) GetRequest(id int64) (*QuotaRequest, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return c.unmarshalRequest(conn, fmt.Sprintf("%s%d", requestKeyPrefix, id))
+}
+
+// ListRequests returns all requests ordered by ID. If status is not empty only
+// requests with the given status are returned.
+func (c *Cache) ListRequests(status string) ([]*QuotaRequest, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", requestKeyPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]*QuotaRequest, 0, len(keys))
+	for _, key := range keys {
+		r, err := c.unmarshalRequest(conn, key)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"key": key,
+			}).Error("Failed to fetch quota request")
+			continue
+		}
+
+		if len(status) > 0 && r.Status != status {
+			continue
+		}
+
+		requests = append(requests, r)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ID < requests[j].ID
+	})
+
+	return requests, nil
+}
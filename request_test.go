@@ -0,0 +1,33 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"testing"
+)
+
+func TestQuotaRequestLimits(t *testing.T) {
+	current := &Quota{SoftLimit: 100, HardLimit: 500, SoftLimitInodes: 1000, HardLimitInodes: 5000}
+
+	tests := []struct {
+		request QuotaRequest
+		current *Quota
+		want    Limits
+	}{
+		// Custom hard limits above the new soft limits are kept
+		{QuotaRequest{SoftLimit: 200}, current, Limits{SoftLimit: 200, HardLimit: 500, SoftLimitInodes: 1000, HardLimitInodes: 5000}},
+		{QuotaRequest{SoftLimit: 200, SoftLimitInodes: 2000}, current, Limits{SoftLimit: 200, HardLimit: 500, SoftLimitInodes: 2000, HardLimitInodes: 5000}},
+		// The backend picks new hard limits once the soft limits pass them
+		{QuotaRequest{SoftLimit: 600, SoftLimitInodes: 6000}, current, Limits{SoftLimit: 600, SoftLimitInodes: 6000}},
+		{QuotaRequest{SoftLimit: 200}, nil, Limits{SoftLimit: 200}},
+	}
+
+	for _, test := range tests {
+		got := test.request.Limits(test.current)
+		if *got != test.want {
+			t.Errorf("Limits(%+v) = %+v, want %+v", test.request, *got, test.want)
+		}
+	}
+}
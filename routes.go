@@ -0,0 +1,166 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Route maps a path prefix, as spelled by iquota clients and stored in the
+// cache, to the named storage backend that owns it and the storage-native
+// path the backend knows it by.
+type Route struct {
+	Prefix      string `mapstructure:"prefix" json:"prefix"`
+	Backend     string `mapstructure:"backend" json:"backend"`
+	StoragePath string `mapstructure:"storage_path" json:"storage_path"`
+}
+
+// Routing table sorted longest prefix first
+type Routes []*Route
+
+// NewRoutes reads the routing table configured under key. Backend names either
+// refer to a storage backend under backends or name the source used by a
+// collector command (vast for ivast, panfs for ipanfs). storage_path defaults
+// to the prefix. For example:
+//
+//	routes:
+//	    - prefix: /vast
+//	      backend: vast
+//	      storage_path: /
+//	    - prefix: /panasas
+//	      backend: panfs
+//	      storage_path: /
+func NewRoutes(v *viper.Viper, key string) (Routes, error) {
+	var routes Routes
+	err := v.UnmarshalKey(key, &routes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", key, err)
+	}
+
+	for _, r := range routes {
+		if !filepath.IsAbs(r.Prefix) {
+			return nil, fmt.Errorf("Invalid route prefix must be an absolute path: %s", r.Prefix)
+		}
+		if len(r.Backend) == 0 {
+			return nil, fmt.Errorf("Missing backend for route %s", r.Prefix)
+		}
+		if len(r.StoragePath) == 0 {
+			r.StoragePath = r.Prefix
+		}
+		if !filepath.IsAbs(r.StoragePath) {
+			return nil, fmt.Errorf("Invalid route storage_path must be an absolute path: %s", r.StoragePath)
+		}
+
+		r.Prefix = filepath.Clean(r.Prefix)
+		r.StoragePath = filepath.Clean(r.StoragePath)
+	}
+
+	routes.sort()
+	if err := routes.check(); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// Returns an error if more than one route has the same prefix
+func (r Routes) check() error {
+	seen := make(map[string]bool, len(r))
+	for _, route := range r {
+		if seen[route.Prefix] {
+			return fmt.Errorf("Duplicate route for %s", route.Prefix)
+		}
+		seen[route.Prefix] = true
+	}
+
+	return nil
+}
+
+// Sort longest prefix first so nested paths match the most specific route
+func (r Routes) sort() {
+	sort.SliceStable(r, func(i, j int) bool {
+		return len(r[i].Prefix) > len(r[j].Prefix)
+	})
+}
+
+// TrimPathPrefix returns the part of path below prefix and true if path is
+// prefix or is nested below it. Both must be clean absolute paths.
+func TrimPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "", true
+	}
+	if prefix == "/" {
+		return path, true
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return path[len(prefix):], true
+	}
+
+	return "", false
+}
+
+// Match returns the most specific route for path
+func (r Routes) Match(path string) (*Route, bool) {
+	path = filepath.Clean(path)
+	for _, route := range r {
+		if _, ok := TrimPathPrefix(path, route.Prefix); ok {
+			return route, true
+		}
+	}
+
+	return nil, false
+}
+
+// FromStorage translates the storage-native path reported by backend to the
+// client path of the most specific route. Returns false if no route to
+// backend covers path.
+func (r Routes) FromStorage(backend, path string) (string, bool) {
+	path = filepath.Clean(path)
+
+	var best *Route
+	for _, route := range r {
+		if route.Backend != backend {
+			continue
+		}
+		if _, ok := TrimPathPrefix(path, route.StoragePath); !ok {
+			continue
+		}
+		if best == nil || len(route.StoragePath) > len(best.StoragePath) {
+			best = route
+		}
+	}
+
+	if best == nil {
+		return path, false
+	}
+
+	return best.FromStorage(path), true
+}
+
+// ToStorage translates path under the route prefix to the storage-native path
+func (r *Route) ToStorage(path string) string {
+	rest, ok := TrimPathPrefix(filepath.Clean(path), r.Prefix)
+	if !ok {
+		return path
+	}
+
+	return filepath.Join(r.StoragePath, rest)
+}
+
+// FromStorage translates a storage-native path under the route storage_path
+// to the client path
+func (r *Route) FromStorage(path string) string {
+	rest, ok := TrimPathPrefix(filepath.Clean(path), r.StoragePath)
+	if !ok {
+		return path
+	}
+
+	return filepath.Join(r.Prefix, rest)
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"testing"
+)
+
+func TestTrimPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		rest   string
+		ok     bool
+	}{
+		{"/vast", "/vast", "", true},
+		{"/vast/projects", "/vast", "/projects", true},
+		{"/vastly", "/vast", "", false},
+		{"/other", "/vast", "", false},
+		{"/vast", "/", "/vast", true},
+		{"/", "/", "", true},
+	}
+
+	for _, test := range tests {
+		rest, ok := TrimPathPrefix(test.path, test.prefix)
+		if rest != test.rest || ok != test.ok {
+			t.Errorf("TrimPathPrefix(%s, %s) = %q, %t, want %q, %t", test.path, test.prefix, rest, ok, test.rest, test.ok)
+		}
+	}
+}
@@ -11,6 +11,7 @@ mkdir -p ${REL_DIR}
 
 cp ./cmd/iquota/iquota ${REL_DIR}/ 
 cp ./cmd/iquota/iquota.yaml.sample ${REL_DIR}/ 
+cp ./cmd/iquota/iquota-login.sh ${REL_DIR}/ 
 cp ./cmd/iquota/iquota.spec ${REL_DIR}/
 cp ./README.rst ${REL_DIR}/ 
 cp ./AUTHORS.rst ${REL_DIR}/ 
@@ -0,0 +1,188 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	collectorKeyPrefix = "iquota:collector:"
+
+	// Collector types
+	CollectorBackend  = "backend"
+	CollectorCommand  = "command"
+	CollectorExternal = "external"
+
+	// Set to the collector name in the environment of commands run by
+	// iquota-server collectors
+	CollectorEnv = "IQUOTA_COLLECTOR"
+)
+
+// Status of the most recent run of a quota collector (ivast, ipanfs)
+type CollectorStatus struct {
+	Name        string    `json:"name"`
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	Duration    float64   `json:"duration_seconds"`
+	Count       int       `json:"count"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// State of a quota collector. Collectors hosted by iquota-server either fetch
+// quotas from a storage backend or run a command. Collectors that record their
+// status but are not hosted by the server (from cron) are external.
+type CollectorInfo struct {
+	Name     string           `json:"name"`
+	Type     string           `json:"type"`
+	Interval int              `json:"interval_seconds"`
+	Jitter   int              `json:"jitter_seconds"`
+	Timeout  int              `json:"timeout_seconds"`
+	Running  bool             `json:"running"`
+	Started  *time.Time       `json:"started,omitempty"`
+	NextRun  *time.Time       `json:"next_run,omitempty"`
+	Status   *CollectorStatus `json:"status,omitempty"`
+}
+
+// Ping checks the connection to the redis server
+func (c *Cache) Ping() error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PING")
+	return err
+}
+
+// RecordCollectorRun saves the status of a collector run that started at
+// start and cached count quotas. If err is not nil the run is recorded as
+// failed and the time of the last successful run is kept.
+func (c *Cache) RecordCollectorRun(name string, start time.Time, count int, runErr error) error {
+	status, err := c.GetCollectorStatus(name)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		status = &CollectorStatus{Name: name}
+	}
+
+	status.LastRun = start
+	status.Duration = time.Since(start).Seconds()
+	status.Count = count
+	status.Error = ""
+	if runErr != nil {
+		status.Error = runErr.Error()
+	} else {
+		status.LastSuccess = start
+	}
+
+	out, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", collectorKeyPrefix+name, out)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":       err.Error(),
+			"collector": name,
+		}).Error("Failed to save collector status")
+		return err
+	}
+
+	return nil
+}
+
+// RecordCommandRun records the run of a collector command (ivast, ipanfs)
+// started from cron. When run by an iquota-server collector the server records
+// the run instead, so only the count of a successful run is printed to stdout
+// for it to pick up.
+func (c *Cache) RecordCommandRun(name string, start time.Time, count int, runErr error) error {
+	if len(os.Getenv(CollectorEnv)) > 0 {
+		if runErr == nil {
+			fmt.Println(count)
+		}
+		return nil
+	}
+
+	return c.RecordCollectorRun(name, start, count, runErr)
+}
+
+func (c *Cache) unmarshalCollectorStatus(conn redis.Conn, key string) (*CollectorStatus, error) {
+	rawJson, err := redis.Bytes(conn.Do("GET", key))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	status := &CollectorStatus{}
+	err = json.Unmarshal(rawJson, status)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid collector status %s: %w", key, err)
+	}
+
+	return status, nil
+}
+
+// GetCollectorStatus returns the status of the last run of the named collector
+func (c *Cache) GetCollectorStatus(name string) (*CollectorStatus, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return c.unmarshalCollectorStatus(conn, collectorKeyPrefix+name)
+}
+
+// ListCollectorStatus returns the status of all collectors that have run
+func (c *Cache) ListCollectorStatus() ([]*CollectorStatus, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", collectorKeyPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*CollectorStatus, 0, len(keys))
+	for _, key := range keys {
+		status, err := c.unmarshalCollectorStatus(conn, key)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"key": key,
+			}).Error("Failed to fetch collector status")
+			continue
+		}
+		if len(status.Name) == 0 {
+			status.Name = strings.TrimPrefix(key, collectorKeyPrefix)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
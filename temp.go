@@ -0,0 +1,201 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package iquota
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	tempKeyPrefix = "iquota:temp:"
+	lockKeyPrefix = "iquota:lock:"
+)
+
+// Time-boxed change to the limits of a directory quota. The original limits
+// are restored once the increase expires.
+type TempIncrease struct {
+	Path     string    `json:"path"`
+	Limits   Limits    `json:"limits"`
+	Original Limits    `json:"original"`
+	Expires  time.Time `json:"expires"`
+	Created  time.Time `json:"created"`
+	User     string    `json:"user"`
+	Reason   string    `json:"reason,omitempty"`
+
+	// Set once the owner has been warned of the upcoming revert
+	Warned bool `json:"warned"`
+}
+
+// QuotaLimits returns the current limits of a quota
+func (q *Quota) QuotaLimits() Limits {
+	return Limits{
+		SoftLimit:       q.SoftLimit,
+		HardLimit:       q.HardLimit,
+		SoftLimitInodes: q.SoftLimitInodes,
+		HardLimitInodes: q.HardLimitInodes,
+	}
+}
+
+// SetTempIncrease saves a temporary increase. Any existing increase for the
+// same path is replaced.
+func (c *Cache) SetTempIncrease(t *TempIncrease) error {
+	out, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", tempKeyPrefix+t.Path, out)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":  err.Error(),
+			"path": t.Path,
+		}).Error("Failed to save temporary quota increase")
+		return err
+	}
+
+	return nil
+}
+
+func (c *Cache) unmarshalTempIncrease(conn redis.Conn, key string) (*TempIncrease, error) {
+	rawJson, err := redis.Bytes(conn.Do("GET", key))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	t := &TempIncrease{}
+	err = json.Unmarshal(rawJson, t)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid temporary quota increase %s: %w", key, err)
+	}
+
+	return t, nil
+}
+
+// GetTempIncrease returns the temporary increase for path
+func (c *Cache) GetTempIncrease(path string) (*TempIncrease, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return c.unmarshalTempIncrease(conn, tempKeyPrefix+path)
+}
+
+// DeleteTempIncrease removes the temporary increase for path
+func (c *Cache) DeleteTempIncrease(path string) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", tempKeyPrefix+path)
+	return err
+}
+
+// ListTempIncreases returns all temporary increases ordered by expiry
+func (c *Cache) ListTempIncreases() ([]*TempIncrease, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", tempKeyPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	increases := make([]*TempIncrease, 0, len(keys))
+	for _, key := range keys {
+		t, err := c.unmarshalTempIncrease(conn, key)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"key": key,
+			}).Error("Failed to fetch temporary quota increase")
+			continue
+		}
+		if len(t.Path) == 0 {
+			t.Path = strings.TrimPrefix(key, tempKeyPrefix)
+		}
+		increases = append(increases, t)
+	}
+
+	sort.Slice(increases, func(i, j int) bool {
+		return increases[i].Expires.Before(increases[j].Expires)
+	})
+
+	return increases, nil
+}
+
+// Releases a lock only if it still holds the token it was taken with so a
+// lock that expired and was taken by another server is left alone
+var releaseLockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireLock takes the named lock for ttl. Returns a random token identifying
+// this holder, or false if the lock is already held. Used to ensure periodic
+// jobs only run on one server.
+func (c *Cache) AcquireLock(name string, ttl time.Duration) (string, bool, error) {
+	conn, err := c.redisDial()
+	if err != nil {
+		return "", false, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err = redis.String(conn.Do("SET", lockKeyPrefix+name, token, "NX", "PX", ttl.Milliseconds()))
+	if errors.Is(err, redis.ErrNil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return token, true, nil
+}
+
+// ReleaseLock releases the named lock before it expires. Does nothing if the
+// lock is no longer held with token.
+func (c *Cache) ReleaseLock(name, token string) error {
+	conn, err := c.redisDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = releaseLockScript.Do(conn, lockKeyPrefix+name, token)
+	return err
+}
@@ -0,0 +1,366 @@
+// Copyright 2020 iquota Authors. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+// Package vast implements an iquota storage backend for VAST directory
+// quotas using the VAST REST api.
+package vast
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/iquota"
+)
+
+/*
+   Example vast api payload
+   {
+       "cluster": "",
+       "cluster_id": 1,
+       "grace_period": "7 00:00:00",
+       "guid": "",
+       "hard_limit": 11000000000,
+       "hard_limit_inodes": 10100000,
+       "id": 1441,
+       "internal": false,
+       "name": "",
+       "path": "",
+       "pretty_grace_period": " 7 days",
+       "pretty_grace_period_expiration": null,
+       "pretty_state": "OK",
+       "soft_limit": 10000000000,
+       "soft_limit_inodes": 10000000,
+       "state": "OK",
+       "sync_state": "SYNCHRONIZED",
+       "tenant_id": 1,
+       "time_to_block": null,
+       "title": "",
+       "url": "",
+       "used_capacity": 1038804300,
+       "used_capacity_tb": 0.001,
+       "used_effective_capacity": 1043824640,
+       "used_effective_capacity_tb": 0.001,
+       "used_inodes": 282
+   },
+*/
+
+const (
+	DriverName = "vast"
+
+	// Headroom added to soft limits when no hard limit is given
+	HardLimitPadding       = 1000000000 // 1G
+	HardLimitInodesPadding = 100000     // 100K
+
+	DefaultGracePeriod = "7 00:00:00"
+)
+
+var (
+	ErrMultipleQuotas = errors.New("more than one quota exists for path")
+)
+
+func init() {
+	iquota.RegisterDriver(DriverName, func(name string, conf *viper.Viper) (iquota.Backend, error) {
+		c := &Client{
+			name:     name,
+			Host:     conf.GetString("host"),
+			User:     conf.GetString("user"),
+			Password: conf.GetString("password"),
+		}
+
+		if len(c.Host) == 0 {
+			return nil, errors.New("Missing vast host")
+		}
+
+		return c, nil
+	})
+}
+
+// Quota as returned by the vast api
+type Quota struct {
+	ID                    int    `json:"id"`
+	HardLimit             int    `json:"hard_limit"`
+	HardLimitInodes       int    `json:"hard_limit_inodes"`
+	Path                  string `json:"path"`
+	GracePeriod           string `json:"pretty_grace_period"`
+	GraceExpiration       string `json:"pretty_grace_period_expiration"`
+	SoftLimit             int    `json:"soft_limit"`
+	SoftLimitInodes       int    `json:"soft_limit_inodes"`
+	State                 string `json:"state"`
+	SyncState             string `json:"sync_state"`
+	UsedCapacity          int    `json:"used_capacity"`
+	UsedEffectiveCapacity int    `json:"used_effective_capacity"`
+	UsedInodes            int    `json:"used_inodes"`
+}
+
+// ToQuota converts a vast quota to an iquota Quota
+func (q *Quota) ToQuota() *iquota.Quota {
+	return &iquota.Quota{
+		Path:            q.Path,
+		GracePeriod:     q.GracePeriod,
+		GraceExpiration: q.GraceExpiration,
+		HardLimit:       q.HardLimit,
+		SoftLimit:       q.SoftLimit,
+		Used:            q.UsedEffectiveCapacity,
+		HardLimitInodes: q.HardLimitInodes,
+		SoftLimitInodes: q.SoftLimitInodes,
+		UsedInodes:      q.UsedInodes,
+		Source:          DriverName,
+	}
+}
+
+// Client for the vast api. Implements the iquota.Backend and iquota.Collector
+// interfaces
+type Client struct {
+	Host     string
+	User     string
+	Password string
+
+	name string
+}
+
+func (c *Client) httpClient() *http.Client {
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	return &http.Client{Transport: tr}
+}
+
+// FetchQuotas returns all quotas for dirPath. If dirPath is empty all quotas
+// are returned.
+func (c *Client) FetchQuotas(dirPath string) ([]Quota, error) {
+	return c.FetchQuotasContext(context.Background(), dirPath)
+}
+
+// FetchQuotasContext is FetchQuotas with a context to cancel the request
+func (c *Client) FetchQuotasContext(ctx context.Context, dirPath string) ([]Quota, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/api/quotas/", c.Host), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dirPath) > 0 {
+		params := req.URL.Query()
+		params.Add("path", dirPath)
+		req.URL.RawQuery = params.Encode()
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.User, c.Password)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("Failed to fetch vast quota with HTTP status code: %d", res.StatusCode)
+	}
+
+	rawJson, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var vq []Quota
+	err = json.Unmarshal(rawJson, &vq)
+	if err != nil {
+		return nil, err
+	}
+
+	return vq, nil
+}
+
+// CreateQuota creates a new directory quota on path
+func (c *Client) CreateQuota(path string, limits *iquota.Limits) error {
+	apiUrl := fmt.Sprintf("https://%s/api/quotas/", c.Host)
+	return c.setQuota("POST", apiUrl, path, limits)
+}
+
+// UpdateQuota updates an existing directory quota
+func (c *Client) UpdateQuota(quota *Quota, limits *iquota.Limits) error {
+	apiUrl := fmt.Sprintf("https://%s/api/quotas/%d/", c.Host, quota.ID)
+	return c.setQuota("PATCH", apiUrl, quota.Path, limits)
+}
+
+// RemoveQuota deletes an existing directory quota
+func (c *Client) RemoveQuota(quota *Quota) error {
+	apiUrl := fmt.Sprintf("https://%s/api/quotas/%d/", c.Host, quota.ID)
+
+	req, err := http.NewRequest("DELETE", apiUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.User, c.Password)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 200 || res.StatusCode == 204 {
+		return nil
+	}
+
+	rawBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("Failed with code %d deleting vast quota for path %s: %s", res.StatusCode, quota.Path, string(rawBody))
+}
+
+// ResolveLimits returns limits with any zero hard limits padded from the soft
+// limits
+func (c *Client) ResolveLimits(limits *iquota.Limits) *iquota.Limits {
+	l := *limits
+	if l.HardLimit == 0 {
+		l.HardLimit = l.SoftLimit + HardLimitPadding
+	}
+	if l.HardLimitInodes == 0 {
+		l.HardLimitInodes = l.SoftLimitInodes + HardLimitInodesPadding
+	}
+
+	return &l
+}
+
+func (c *Client) setQuota(verb, apiUrl, path string, limits *iquota.Limits) error {
+	limits = c.ResolveLimits(limits)
+
+	payload := map[string]interface{}{
+		"name":              path,
+		"path":              path,
+		"grace_period":      DefaultGracePeriod,
+		"soft_limit":        limits.SoftLimit,
+		"hard_limit":        limits.HardLimit,
+		"soft_limit_inodes": limits.SoftLimitInodes,
+		"hard_limit_inodes": limits.HardLimitInodes,
+		"create_dir":        "False",
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(verb, apiUrl, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.User, c.Password)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 201 || res.StatusCode == 200 {
+		return nil
+	}
+
+	rawBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("Failed with code %d setting vast quota for path %s: %s", res.StatusCode, path, string(rawBody))
+}
+
+func (c *Client) findQuota(path string) (*Quota, error) {
+	path = strings.TrimSuffix(path, "/")
+	quotas, err := c.FetchQuotas(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only keep exact matches in case the api returns nested quotas
+	var found []Quota
+	for _, q := range quotas {
+		if q.Path == path {
+			found = append(found, q)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, iquota.ErrNotFound
+	case 1:
+		return &found[0], nil
+	}
+
+	return nil, ErrMultipleQuotas
+}
+
+// Name of the backend
+func (c *Client) Name() string {
+	if len(c.name) == 0 {
+		return DriverName
+	}
+
+	return c.name
+}
+
+// FetchAllQuotas fetches every quota from vast
+func (c *Client) FetchAllQuotas(ctx context.Context) ([]*iquota.Quota, error) {
+	vq, err := c.FetchQuotasContext(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := make([]*iquota.Quota, 0, len(vq))
+	for _, q := range vq {
+		quotas = append(quotas, q.ToQuota())
+	}
+
+	return quotas, nil
+}
+
+// GetQuota fetches the current quota for path from vast
+func (c *Client) GetQuota(path string) (*iquota.Quota, error) {
+	q, err := c.findQuota(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.ToQuota(), nil
+}
+
+// SetQuota creates or updates the quota on path. If no inode limit is given
+// the existing inode limit is kept.
+func (c *Client) SetQuota(path string, limits *iquota.Limits) error {
+	q, err := c.findQuota(path)
+	if errors.Is(err, iquota.ErrNotFound) {
+		return c.CreateQuota(strings.TrimSuffix(path, "/"), limits)
+	}
+	if err != nil {
+		return err
+	}
+
+	if limits.SoftLimitInodes == 0 {
+		l := *limits
+		l.SoftLimitInodes = q.SoftLimitInodes
+		l.HardLimitInodes = q.HardLimitInodes
+		limits = &l
+	}
+
+	return c.UpdateQuota(q, limits)
+}
+
+// DeleteQuota removes the quota on path
+func (c *Client) DeleteQuota(path string) error {
+	q, err := c.findQuota(path)
+	if err != nil {
+		return err
+	}
+
+	return c.RemoveQuota(q)
+}